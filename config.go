@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"time"
+)
+
+type config struct {
+	artFallback    bool
+	musicBrainzURL string
+	coverArtURL    string
+	libraryDir     string
+	libraryRescan  time.Duration
+	cleanup        bool
+	cleanupRules   string
+	overridesFile  string
+	artDir         string
+	blocklistFile  string
+	maskWords      string
+	maskChar       string
+	staleAfter     time.Duration
+	idleMode       string
+	idleMessage    string
+	logFile        string
+	logFormat      string
+	logMaxSize     int
+	logBackups     int
+	debug          bool
+	stateFile      string
+	sourceRule     string
+	sourcePriority string
+	token          string
+	tenantsFile    string
+	artSlots       int
+	tenantArtSlots int
+	tenantArtRate  int
+	relayTo        string
+	relayToken     string
+	input          string
+	watchFile      string
+	watchFormat    string
+	watchCover     string
+}
+
+var cfg config
+
+func parseFlags() {
+	flag.BoolVar(&cfg.artFallback, "art-fallback", false, "look up missing album art on MusicBrainz / Cover Art Archive, which sends them each such track's artist and title")
+	flag.StringVar(&cfg.musicBrainzURL, "musicbrainz-url", "https://musicbrainz.org", "MusicBrainz API base URL")
+	flag.StringVar(&cfg.coverArtURL, "coverart-url", "https://coverartarchive.org", "Cover Art Archive base URL")
+	flag.StringVar(&cfg.libraryDir, "library", "", "music folder to index for metadata and embedded art")
+	flag.DurationVar(&cfg.libraryRescan, "library-rescan", time.Hour, "how often to rescan the music folder, 0 to scan only at startup")
+	flag.BoolVar(&cfg.cleanup, "cleanup", true, "clean up titles and artists (byline splitting, \"(Official Video)\", feat. artists)")
+	flag.StringVar(&cfg.cleanupRules, "cleanup-rules", "", "JSON file with cleanup stages and extra regex rules")
+	flag.StringVar(&cfg.overridesFile, "overrides", defaultOverridesFile(), "JSON file holding per-track overrides, empty to disable")
+	flag.StringVar(&cfg.artDir, "art-dir", "", "folder that local art in overrides may come from; local art is off without it")
+	flag.StringVar(&cfg.blocklistFile, "blocklist", "", "JSON file with artists, titles and patterns to keep off the overlay")
+	flag.StringVar(&cfg.maskWords, "mask-words", "", "word list file; listed words are masked in displayed titles and artists")
+	flag.StringVar(&cfg.maskChar, "mask-char", "*", "character used to mask words")
+	flag.DurationVar(&cfg.staleAfter, "stale-after", 30*time.Second, "switch to the idle screen when no track was posted for this long, 0 to never")
+	flag.StringVar(&cfg.idleMode, "idle", "message", "idle screen: message, hidden, brb or last (last played track)")
+	flag.StringVar(&cfg.idleMessage, "idle-message", "Waiting for track...", "text of the \"message\" idle screen")
+	flag.StringVar(&cfg.logFile, "log-file", defaultLogFile(), "log file, rotated by size; empty to log to the console only")
+	flag.StringVar(&cfg.logFormat, "log-format", "text", "log format: text or json")
+	flag.IntVar(&cfg.logMaxSize, "log-max-size", 5, "rotate the log file after this many MB")
+	flag.IntVar(&cfg.logBackups, "log-backups", 3, "number of rotated log files to keep")
+	flag.BoolVar(&cfg.debug, "debug", false, "log at debug level, including full webhook payloads")
+	flag.StringVar(&cfg.stateFile, "state-file", defaultStateFile(), "where to keep the current track and art across restarts, empty to disable")
+	flag.StringVar(&cfg.sourceRule, "source-rule", "recent", "which source to show when several play at once: recent (last one to start) or priority")
+	flag.StringVar(&cfg.sourcePriority, "source-priority", "", "comma-separated source IDs, highest first, for -source-rule priority")
+	flag.StringVar(&cfg.token, "token", "", "require this token for posting tracks and for the admin pages")
+	flag.StringVar(&cfg.tenantsFile, "tenants", "", "JSON file listing tenants, each served under /s/{name}/ with its own token and state")
+	flag.IntVar(&cfg.artSlots, "art-slots", 4, "with -tenants, album art downloads running at once across all tenants")
+	flag.IntVar(&cfg.tenantArtSlots, "tenant-art-slots", 1, "with -tenants, album art downloads one tenant may run at once")
+	flag.IntVar(&cfg.tenantArtRate, "tenant-art-per-minute", 30, "with -tenants, album art updates one tenant may start per minute, 0 for no limit")
+	flag.StringVar(&cfg.relayTo, "relay-to", "", "also forward the state and art to the instance at this URL, e.g. http://stream-pc:17890")
+	flag.StringVar(&cfg.relayToken, "relay-token", "", "the -token of the -relay-to instance")
+	flag.StringVar(&cfg.input, "input", "", "also read tracks, one JSON object or key=value line each, from this named pipe or file, or - for stdin")
+	flag.StringVar(&cfg.watchFile, "watch", "", "also follow this now-playing text file written by a player")
+	flag.StringVar(&cfg.watchFormat, "watch-format", "{artist} - {title}", "how to read the -watch file: a template with {field}s, or a regex with named groups")
+	flag.StringVar(&cfg.watchCover, "watch-cover", "", "image file next to the -watch file to show as album art")
+	flag.Parse()
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
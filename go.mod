@@ -0,0 +1,8 @@
+module github.com/StuxMirai/piff-music
+
+go 1.22
+
+require (
+	golang.org/x/image v0.24.0
+	golang.org/x/text v0.22.0
+)
@@ -0,0 +1,265 @@
+// Package googleimg parses and rewrites the size options of Google-hosted
+// thumbnail URLs: googleusercontent.com/ggpht.com images, whose options
+// follow the last '=' in the path (=w544-h544-l90-rj, =s512-c), and
+// i.ytimg.com video thumbnails, whose size is picked by file name
+// (hqdefault.jpg, maxresdefault.jpg).
+package googleimg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrUnsupported = errors.New("googleimg: not a googleusercontent or ytimg URL")
+
+type Kind int
+
+const (
+	// GoogleUserContent covers lh*.googleusercontent.com and yt*.ggpht.com
+	GoogleUserContent Kind = iota + 1
+	// YTImg covers i.ytimg.com and its numbered mirrors
+	YTImg
+)
+
+// Image is a parsed thumbnail URL. The zero value is not usable; build
+// one with Parse.
+type Image struct {
+	kind Kind
+	u    url.URL
+
+	// googleusercontent: path before the options and the option tokens
+	// in their original order, size tokens included
+	base    string
+	options []string
+
+	// ytimg: directory holding the variant and the file extension
+	dir string
+	ext string
+	// variant is the ytimg file name without extension, e.g. hqdefault
+	variant string
+}
+
+// ytimg variants by width, smallest first
+var ytVariants = []struct {
+	name  string
+	width int
+}{
+	{"default", 120},
+	{"mqdefault", 320},
+	{"hqdefault", 480},
+	{"sddefault", 640},
+	{"maxresdefault", 1280},
+}
+
+// optionToken matches a single googleusercontent option: a short letter
+// prefix optionally followed by a number or hex value (w544, s0, l90, rj,
+// c0x00ffffff)
+var optionToken = regexp.MustCompile(`^[a-z]{1,3}(?:\d+|0x[0-9a-fA-F]+)?$`)
+
+func Parse(raw string) (*Image, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, ErrUnsupported
+	}
+	host := strings.ToLower(u.Hostname())
+	switch {
+	case strings.HasSuffix(host, ".googleusercontent.com"), strings.HasSuffix(host, ".ggpht.com"):
+		return parseGoogleUserContent(u), nil
+	case host == "i.ytimg.com" || (strings.HasPrefix(host, "i") && strings.HasSuffix(host, ".ytimg.com")):
+		return parseYTImg(u)
+	}
+	return nil, ErrUnsupported
+}
+
+func parseGoogleUserContent(u *url.URL) *Image {
+	img := &Image{kind: GoogleUserContent, u: *u, base: u.Path}
+	i := strings.LastIndexByte(u.Path, '=')
+	if i < 0 {
+		return img
+	}
+	// Only treat the suffix as options if every token looks like one;
+	// otherwise the '=' belongs to the path itself
+	tokens := strings.Split(u.Path[i+1:], "-")
+	for _, t := range tokens {
+		if !optionToken.MatchString(t) {
+			return img
+		}
+	}
+	img.base = u.Path[:i]
+	img.options = tokens
+	return img
+}
+
+func parseYTImg(u *url.URL) (*Image, error) {
+	dir, file := u.Path, ""
+	if i := strings.LastIndexByte(u.Path, '/'); i >= 0 {
+		dir, file = u.Path[:i], u.Path[i+1:]
+	}
+	name, ext, ok := strings.Cut(file, ".")
+	if !ok || dir == "" {
+		return nil, ErrUnsupported
+	}
+	return &Image{kind: YTImg, u: *u, dir: dir, ext: ext, variant: name}, nil
+}
+
+func (img *Image) Kind() Kind { return img.kind }
+
+// Options returns the googleusercontent option tokens, e.g.
+// ["w544", "h544", "l90", "rj"]. It is empty for ytimg URLs.
+func (img *Image) Options() []string {
+	return append([]string(nil), img.options...)
+}
+
+// Size reports the current requested size: the larger of w/h or s for
+// googleusercontent, the variant width for ytimg. 0 means unknown.
+func (img *Image) Size() int {
+	if img.kind == YTImg {
+		for _, v := range ytVariants {
+			if v.name == img.variant {
+				return v.width
+			}
+		}
+		return 0
+	}
+	size := 0
+	for _, t := range img.options {
+		if n, ok := sizeToken(t); ok {
+			size = max(size, n)
+		}
+	}
+	return size
+}
+
+// WithSize returns a copy asking for a size x size image. For
+// googleusercontent the existing w/h/s tokens are replaced and every
+// other option (-rj, -l90, -c, ...) is kept. For ytimg the smallest
+// variant at least size wide is chosen.
+func (img *Image) WithSize(size int) *Image {
+	out := *img
+	if img.kind == YTImg {
+		out.variant = ytVariants[len(ytVariants)-1].name
+		for _, v := range ytVariants {
+			if v.width >= size {
+				out.variant = v.name
+				break
+			}
+		}
+		return &out
+	}
+
+	square := false
+	rest := make([]string, 0, len(img.options)+2)
+	for _, t := range img.options {
+		if _, ok := sizeToken(t); ok {
+			square = square || t[0] == 's'
+			continue
+		}
+		rest = append(rest, t)
+	}
+	if square {
+		out.options = append([]string{"s" + strconv.Itoa(size)}, rest...)
+	} else {
+		out.options = append([]string{"w" + strconv.Itoa(size), "h" + strconv.Itoa(size)}, rest...)
+	}
+	return &out
+}
+
+func (img *Image) String() string {
+	u := img.u
+	switch img.kind {
+	case YTImg:
+		u.Path = img.dir + "/" + img.variant + "." + img.ext
+	default:
+		u.Path = img.base
+		if len(img.options) > 0 {
+			u.Path += "=" + strings.Join(img.options, "-")
+		}
+	}
+	u.RawPath = ""
+	return u.String()
+}
+
+// Candidates returns one URL per requested size, largest first, with
+// duplicates removed (ytimg maps several sizes onto the same variant)
+func (img *Image) Candidates(sizes ...int) []string {
+	sorted := append([]int(nil), sizes...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+	seen := map[string]bool{}
+	var out []string
+	for _, s := range sorted {
+		c := img.WithSize(s).String()
+		if !seen[c] {
+			seen[c] = true
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func sizeToken(t string) (int, bool) {
+	if len(t) < 2 || (t[0] != 'w' && t[0] != 'h' && t[0] != 's') {
+		return 0, false
+	}
+	n, err := strconv.Atoi(t[1:])
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// Prober picks the best available size with HEAD requests
+type Prober struct {
+	Client *http.Client
+	// Header is sent with every probe, e.g. User-Agent and Referer
+	Header http.Header
+}
+
+// Best returns the first candidate, largest size first, that answers a
+// HEAD request with 200 and an image content type
+func (p *Prober) Best(ctx context.Context, raw string, sizes ...int) (string, error) {
+	img, err := Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	var lastErr error
+	for _, c := range img.Candidates(sizes...) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, c, nil)
+		if err != nil {
+			return "", err
+		}
+		for k, v := range p.Header {
+			req.Header[k] = v
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK && strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
+			return c, nil
+		}
+		lastErr = fmt.Errorf("googleimg: %s: %s", c, resp.Status)
+	}
+	if lastErr == nil {
+		lastErr = errors.New("googleimg: no candidate sizes")
+	}
+	return "", lastErr
+}
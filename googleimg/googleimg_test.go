@@ -0,0 +1,286 @@
+package googleimg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		kind    Kind
+		options []string
+		size    int
+		err     error
+	}{
+		{
+			name:    "square size",
+			raw:     "https://lh3.googleusercontent.com/abc123=s512",
+			kind:    GoogleUserContent,
+			options: []string{"s512"},
+			size:    512,
+		},
+		{
+			name:    "width and height with flags",
+			raw:     "https://lh3.googleusercontent.com/abc123=w544-h544-l90-rj",
+			kind:    GoogleUserContent,
+			options: []string{"w544", "h544", "l90", "rj"},
+			size:    544,
+		},
+		{
+			name:    "color option",
+			raw:     "https://yt3.ggpht.com/abc=s88-c-k-c0x00ffffff-no-rj",
+			kind:    GoogleUserContent,
+			options: []string{"s88", "c", "k", "c0x00ffffff", "no", "rj"},
+			size:    88,
+		},
+		{
+			name: "equals sign in the path",
+			raw:  "https://lh3.googleusercontent.com/a/b=c/not-an=option_list",
+			kind: GoogleUserContent,
+		},
+		{
+			name: "no options",
+			raw:  "https://lh3.googleusercontent.com/abc123",
+			kind: GoogleUserContent,
+		},
+		{
+			name: "ytimg",
+			raw:  "https://i.ytimg.com/vi/dQw4w9WgXcQ/hqdefault.jpg",
+			kind: YTImg,
+			size: 480,
+		},
+		{
+			name: "ytimg mirror",
+			raw:  "https://i9.ytimg.com/vi/dQw4w9WgXcQ/maxresdefault.jpg",
+			kind: YTImg,
+			size: 1280,
+		},
+		{
+			name: "ytimg unknown variant",
+			raw:  "https://i.ytimg.com/vi/dQw4w9WgXcQ/hq720.jpg",
+			kind: YTImg,
+		},
+		{
+			name: "ytimg without a file",
+			raw:  "https://i.ytimg.com/",
+			err:  ErrUnsupported,
+		},
+		{
+			name: "other host",
+			raw:  "https://example.com/cover=s512",
+			err:  ErrUnsupported,
+		},
+		{
+			name: "lookalike host",
+			raw:  "https://googleusercontent.com.example.com/abc=s512",
+			err:  ErrUnsupported,
+		},
+		{
+			name: "not http",
+			raw:  "file:///C:/covers/a.jpg",
+			err:  ErrUnsupported,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Parse(tt.raw)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("Parse(%q) error = %v, want %v", tt.raw, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.raw, err)
+			}
+			if img.Kind() != tt.kind {
+				t.Errorf("Kind() = %v, want %v", img.Kind(), tt.kind)
+			}
+			if got := img.Options(); len(got) != 0 || len(tt.options) != 0 {
+				if !reflect.DeepEqual(got, tt.options) {
+					t.Errorf("Options() = %q, want %q", got, tt.options)
+				}
+			}
+			if got := img.Size(); got != tt.size {
+				t.Errorf("Size() = %d, want %d", got, tt.size)
+			}
+			if got := img.String(); got != tt.raw {
+				t.Errorf("String() = %q, want the input back", got)
+			}
+		})
+	}
+}
+
+func TestWithSize(t *testing.T) {
+	tests := []struct {
+		raw  string
+		size int
+		want string
+	}{
+		{
+			raw:  "https://lh3.googleusercontent.com/abc=s120",
+			size: 512,
+			want: "https://lh3.googleusercontent.com/abc=s512",
+		},
+		{
+			raw:  "https://lh3.googleusercontent.com/abc=w60-h60-l90-rj",
+			size: 544,
+			want: "https://lh3.googleusercontent.com/abc=w544-h544-l90-rj",
+		},
+		{
+			raw:  "https://yt3.ggpht.com/abc=s88-c-k-c0x00ffffff-no-rj",
+			size: 800,
+			want: "https://yt3.ggpht.com/abc=s800-c-k-c0x00ffffff-no-rj",
+		},
+		{
+			raw:  "https://lh3.googleusercontent.com/abc",
+			size: 256,
+			want: "https://lh3.googleusercontent.com/abc=w256-h256",
+		},
+		{
+			raw:  "https://lh3.googleusercontent.com/a/b=c/not-an=option_list",
+			size: 256,
+			want: "https://lh3.googleusercontent.com/a/b=c/not-an=option_list=w256-h256",
+		},
+		{
+			raw:  "https://lh3.googleusercontent.com/abc=s120?x=1",
+			size: 300,
+			want: "https://lh3.googleusercontent.com/abc=s300?x=1",
+		},
+		{
+			raw:  "https://i.ytimg.com/vi/id/default.jpg",
+			size: 400,
+			want: "https://i.ytimg.com/vi/id/hqdefault.jpg",
+		},
+		{
+			raw:  "https://i.ytimg.com/vi/id/hqdefault.jpg",
+			size: 640,
+			want: "https://i.ytimg.com/vi/id/sddefault.jpg",
+		},
+		{
+			raw:  "https://i.ytimg.com/vi_webp/id/hqdefault.webp",
+			size: 4000,
+			want: "https://i.ytimg.com/vi_webp/id/maxresdefault.webp",
+		},
+	}
+	for _, tt := range tests {
+		img, err := Parse(tt.raw)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.raw, err)
+		}
+		if got := img.WithSize(tt.size).String(); got != tt.want {
+			t.Errorf("Parse(%q).WithSize(%d) = %q, want %q", tt.raw, tt.size, got, tt.want)
+		}
+		if got := img.String(); got != tt.raw {
+			t.Errorf("WithSize changed the original to %q", got)
+		}
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	tests := []struct {
+		raw   string
+		sizes []int
+		want  []string
+	}{
+		{
+			raw:   "https://lh3.googleusercontent.com/abc=w60-h60-l90-rj",
+			sizes: []int{256, 1200, 544},
+			want: []string{
+				"https://lh3.googleusercontent.com/abc=w1200-h1200-l90-rj",
+				"https://lh3.googleusercontent.com/abc=w544-h544-l90-rj",
+				"https://lh3.googleusercontent.com/abc=w256-h256-l90-rj",
+			},
+		},
+		{
+			raw:   "https://i.ytimg.com/vi/id/default.jpg",
+			sizes: []int{1280, 700, 544, 480, 300},
+			want: []string{
+				"https://i.ytimg.com/vi/id/maxresdefault.jpg",
+				"https://i.ytimg.com/vi/id/sddefault.jpg",
+				"https://i.ytimg.com/vi/id/hqdefault.jpg",
+				"https://i.ytimg.com/vi/id/mqdefault.jpg",
+			},
+		},
+		{
+			raw:   "https://lh3.googleusercontent.com/abc=s120",
+			sizes: nil,
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		img, err := Parse(tt.raw)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.raw, err)
+		}
+		if got := img.Candidates(tt.sizes...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q).Candidates(%v) =\n%q\nwant\n%q", tt.raw, tt.sizes, got, tt.want)
+		}
+	}
+}
+
+func TestProberBest(t *testing.T) {
+	// The fake host has the 544 and 256 sizes, and a 1200 that isn't an
+	// image
+	var probed []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probed = append(probed, r.URL.Path)
+		if r.Method != http.MethodHead {
+			t.Errorf("method = %s, want HEAD", r.Method)
+		}
+		if got := r.Header.Get("Referer"); got != "https://music.youtube.com/" {
+			t.Errorf("Referer = %q", got)
+		}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "=w1200-h1200-rj"):
+			w.Header().Set("Content-Type", "text/html")
+		case strings.HasSuffix(r.URL.Path, "=w544-h544-rj"), strings.HasSuffix(r.URL.Path, "=w256-h256-rj"):
+			w.Header().Set("Content-Type", "image/jpeg")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	target, _ := url.Parse(srv.URL)
+	client := &http.Client{Transport: rewriteHost{target}}
+	p := &Prober{Client: client, Header: http.Header{"Referer": {"https://music.youtube.com/"}}}
+
+	raw := "https://lh3.googleusercontent.com/abc=w60-h60-rj"
+	got, err := p.Best(context.Background(), raw, 256, 2000, 1200, 544)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://lh3.googleusercontent.com/abc=w544-h544-rj"; got != want {
+		t.Errorf("Best = %q, want %q", got, want)
+	}
+	if want := []string{"/abc=w2000-h2000-rj", "/abc=w1200-h1200-rj", "/abc=w544-h544-rj"}; !reflect.DeepEqual(probed, want) {
+		t.Errorf("probed %q, want %q", probed, want)
+	}
+
+	if _, err := p.Best(context.Background(), raw, 2000); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Best with no available size: err = %v, want a 404", err)
+	}
+	if _, err := p.Best(context.Background(), raw); err == nil {
+		t.Error("Best with no sizes: want an error")
+	}
+	if _, err := p.Best(context.Background(), "https://example.com/a.jpg", 512); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Best with another host: err = %v, want ErrUnsupported", err)
+	}
+}
+
+// rewriteHost sends every request to the test server, keeping the path
+type rewriteHost struct{ target *url.URL }
+
+func (rt rewriteHost) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = rt.target.Scheme, rt.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
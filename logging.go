@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// defaultLogFile puts the log next to the executable, where a streamer
+// can find it when asked for it
+func defaultLogFile() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return "piff-music.log"
+	}
+	return filepath.Join(filepath.Dir(exe), "piff-music.log")
+}
+
+// setupLogging sends log/slog and the standard log package to stderr and,
+// if configured, to a rotating log file
+func setupLogging() error {
+	out, err := logOutput(cfg.logFile, int64(cfg.logMaxSize)<<20, cfg.logBackups)
+	if err != nil {
+		return err
+	}
+
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+	if cfg.debug {
+		opts.Level = slog.LevelDebug
+	}
+	var h slog.Handler
+	switch cfg.logFormat {
+	case "text":
+		h = slog.NewTextHandler(out, opts)
+	case "json":
+		h = slog.NewJSONHandler(out, opts)
+	default:
+		return fmt.Errorf("unknown log format %q (use text or json)", cfg.logFormat)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// logOutput is stderr, plus the rotating file at path if there is one
+func logOutput(path string, maxSize int64, backups int) (io.Writer, error) {
+	if path == "" {
+		return os.Stderr, nil
+	}
+	f, err := openRotatingFile(path, maxSize, backups)
+	if err != nil {
+		return nil, err
+	}
+	return io.MultiWriter(os.Stderr, f), nil
+}
+
+// fatal logs err and exits; for startup errors only
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+// rotatingFile is an append-only log file that is renamed to .1, .2, ...
+// once it grows past maxSize, keeping at most backups old files
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	// f is nil after a rotation that couldn't reopen the file. Lines are
+	// then only on stderr, which logOutput writes to anyway, until a later
+	// write manages to open it.
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return len(p), nil
+		}
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "log rotation failed: %v\n", err)
+			if r.f == nil {
+				return len(p), nil
+			}
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate has to close the file first, since Windows can't rename an open
+// one. If it can't be reopened, f stays nil and the next write retries.
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	r.f = nil
+	// Windows can't rename over an existing file, so drop the oldest first
+	os.Remove(fmt.Sprintf("%s.%d", r.path, r.backups))
+	for i := r.backups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if r.backups > 0 {
+		os.Rename(r.path, r.path+".1")
+	} else {
+		os.Remove(r.path)
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	return r.f.Close()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogOutputRotationFailure(t *testing.T) {
+	stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderr.Close()
+	saved := os.Stderr
+	os.Stderr = stderr
+	defer func() { os.Stderr = saved }()
+
+	dir := filepath.Join(t.TempDir(), "logs")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "piff.log")
+	out, err := logOutput(path, 16, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(line string) {
+		t.Helper()
+		if _, err := out.Write([]byte(line + "\n")); err != nil {
+			t.Fatalf("writing %q: %v", line, err)
+		}
+	}
+
+	write("first line")
+	// The next write rotates, and the file can't be reopened
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	write("while gone 1")
+	write("while gone 2")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	write("back")
+
+	data, err := os.ReadFile(stderr.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first line", "while gone 1", "while gone 2", "back"} {
+		if n := strings.Count(string(data), line+"\n"); n != 1 {
+			t.Errorf("%q is on stderr %d times, want once:\n%s", line, n, data)
+		}
+	}
+	if file, _ := os.ReadFile(path); string(file) != "back\n" {
+		t.Errorf("log file after reopening = %q, want the line written since", file)
+	}
+}
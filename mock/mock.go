@@ -7,15 +7,9 @@ import (
 	"math/rand"
 	"net/http"
 	"time"
-)
 
-type NowPlaying struct {
-	SongName         string `json:"song_name"`
-	Artist           string `json:"artist"`
-	CurrentTimestamp string `json:"current_timestamp"`
-	EndTimestamp     string `json:"end_timestamp"`
-	AlbumArtURL      string `json:"album_art_url"`
-}
+	"github.com/StuxMirai/piff-music/nowplaying"
+)
 
 var songs = []string{"Bohemian Rhapsody", "Stairway to Heaven", "Imagine", "Smells Like Teen Spirit", "Billie Jean"}
 var artists = []string{"Queen", "Led Zeppelin", "John Lennon", "Nirvana", "Michael Jackson"}
@@ -33,7 +27,7 @@ func main() {
 	}
 }
 
-func generateRandomTrack() NowPlaying {
+func generateRandomTrack() nowplaying.NowPlaying {
 	songIndex := rand.Intn(len(songs))
 	artistIndex := rand.Intn(len(artists))
 	artIndex := rand.Intn(len(art))
@@ -44,23 +38,25 @@ func generateRandomTrack() NowPlaying {
 	endTimestamp := fmt.Sprintf("%02d:%02d", totalSeconds/60, totalSeconds%60)
 	currentTimestamp := fmt.Sprintf("%02d:%02d", currentSeconds/60, currentSeconds%60)
 
-	return NowPlaying{
+	return nowplaying.NowPlaying{
 		SongName:         songs[songIndex],
 		Artist:           artists[artistIndex],
 		CurrentTimestamp: currentTimestamp,
 		EndTimestamp:     endTimestamp,
+		CurrentSeconds:   currentSeconds,
+		EndSeconds:       totalSeconds,
 		AlbumArtURL:      art[artIndex],
 	}
 }
 
-func sendTrackData(track NowPlaying) {
+func sendTrackData(track nowplaying.NowPlaying) {
 	jsonData, err := json.Marshal(track)
 	if err != nil {
 		fmt.Println("Error marshalling JSON:", err)
 		return
 	}
 
-	resp, err := http.Post("http://localhost:17890/webhook", "application/json", bytes.NewBuffer(jsonData))
+	resp, err := http.Post("http://localhost:17890/api/v1/state", "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
 		fmt.Println("Error sending data:", err)
 		return
@@ -0,0 +1,257 @@
+package nowplaying
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// apiError is an error response. /api/v1 sends it as {"error": ...}, the
+// older paths as plain text.
+type apiError struct {
+	status  int
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []fieldError `json:"fields,omitempty"`
+}
+
+func newAPIError(status int, code, message string) *apiError {
+	return &apiError{status: status, Code: code, Message: message}
+}
+
+var (
+	errNothingPlaying   = newAPIError(http.StatusNotFound, "not_found", "Nothing to show")
+	errMethodNotAllowed = newAPIError(http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+	errInternal         = newAPIError(http.StatusInternalServerError, "internal", "Internal Server Error")
+)
+
+// Error is the message followed by every field problem
+func (e *apiError) Error() string {
+	msg := e.Message
+	for _, f := range e.Fields {
+		msg += "; " + f.Field + " " + f.Message
+	}
+	return msg
+}
+
+func (e *apiError) write(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.status)
+	json.NewEncoder(w).Encode(struct {
+		Error *apiError `json:"error"`
+	}{e})
+}
+
+// writeLegacy answers the way the pre-v1 paths always have
+func (e *apiError) writeLegacy(w http.ResponseWriter) {
+	if e.status == http.StatusNotFound {
+		http.Error(w, "404 page not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, e.Message, e.status)
+}
+
+func writeData(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Data any `json:"data"`
+	}{v})
+}
+
+// registerAPI adds the /api/v1 routes
+func (h *Handler) registerAPI() {
+	h.mux.HandleFunc("/api/v1/", h.apiNotFound)
+	h.mux.HandleFunc("/api/v1/openapi.json", h.openAPIHandler)
+	h.mux.HandleFunc("/api/v1/state", countStatus(h.metrics.webhookRequests, h.apiStateHandler))
+	h.mux.HandleFunc("/api/v1/state/batch", countStatus(h.metrics.webhookRequests, h.apiBatchHandler))
+	h.mux.HandleFunc("/api/v1/art", h.apiArtHandler)
+	h.mux.HandleFunc("/api/v1/palette", h.apiPaletteHandler)
+	h.mux.HandleFunc("/api/v1/card.png", h.apiCardHandler)
+	h.mux.HandleFunc("/api/v1/privacy", h.apiPrivacyHandler)
+	h.mux.HandleFunc("/api/v1/privacy/", h.apiPrivacyHandler)
+	h.mux.HandleFunc("/api/v1/sources", h.apiSourcesHandler)
+	h.mux.HandleFunc("/api/v1/sources/pin", h.apiSourcePinHandler)
+	h.mux.HandleFunc("/api/v1/relay", h.apiRelayHandler)
+}
+
+// apiCORS lets browser sources call the API from any page. It reports
+// whether the request was a preflight that has been answered.
+func apiCORS(w http.ResponseWriter, r *http.Request, methods string) bool {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodOptions {
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Methods", methods+", OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+func (h *Handler) apiNotFound(w http.ResponseWriter, r *http.Request) {
+	newAPIError(http.StatusNotFound, "not_found", "No such endpoint").write(w)
+}
+
+func (h *Handler) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(openAPIDoc)
+}
+
+type ingestResult struct {
+	Accepted bool       `json:"accepted"`
+	Track    NowPlaying `json:"track"`
+}
+
+func (h *Handler) apiStateHandler(w http.ResponseWriter, r *http.Request) {
+	if apiCORS(w, r, "GET, POST, PATCH") {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		h.health.noteClient(r)
+		writeData(w, h.view())
+	case http.MethodPost:
+		h.health.notePost(r)
+		u, err := h.readTrack(w, r, true)
+		accepted := false
+		if err == nil {
+			accepted, err = h.applyTrack(u)
+		}
+		if err != nil {
+			h.rejectPost(r, err)
+			err.write(w)
+			return
+		}
+		writeData(w, ingestResult{Accepted: accepted, Track: h.view()})
+	case http.MethodPatch:
+		h.health.notePost(r)
+		var p trackPatch
+		err := decodeJSON(w, r, "TrackPatch", &p, false)
+		accepted := false
+		if err == nil {
+			accepted, err = h.patchTrack(p)
+		}
+		if err != nil {
+			h.rejectPost(r, err)
+			err.write(w)
+			return
+		}
+		writeData(w, ingestResult{Accepted: accepted, Track: h.view()})
+	default:
+		errMethodNotAllowed.write(w)
+	}
+}
+
+// readTrack reads a posted track and checks it against the schema.
+// strict rejects unknown fields and anything not sent as JSON, which the
+// pre-v1 webhook always let through.
+func (h *Handler) readTrack(w http.ResponseWriter, r *http.Request, strict bool) (trackUpdate, *apiError) {
+	var u trackUpdate
+	body, err := readBody(w, r, maxBodySize)
+	if err == nil && strict {
+		err = requireJSON(r)
+	}
+	if err != nil {
+		return u, err
+	}
+	slog.Debug("track post", "remote", r.RemoteAddr, "origin", r.Header.Get("Origin"), "payload", string(body))
+	return u, decodeBody(body, "TrackUpdate", &u, strict)
+}
+
+// rejectPost records a track post that could not be used
+func (h *Handler) rejectPost(r *http.Request, err *apiError) {
+	if err.Code == "invalid_json" {
+		h.metrics.webhookDecodeErrs.Inc("")
+	}
+	reason := err.Error()
+	slog.Warn("invalid track post", "remote", r.RemoteAddr, "err", reason)
+	h.health.noteInvalid(reason)
+}
+
+type batchResult struct {
+	Applied int        `json:"applied"`
+	Skipped int        `json:"skipped"`
+	Track   NowPlaying `json:"track"`
+}
+
+func (h *Handler) apiBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if apiCORS(w, r, "POST") {
+		return
+	}
+	if r.Method != http.MethodPost {
+		errMethodNotAllowed.write(w)
+		return
+	}
+	h.health.notePost(r)
+	var batch struct {
+		Updates []trackPatch `json:"updates"`
+	}
+	err := decodeJSON(w, r, "TrackBatch", &batch, false)
+	var applied, skipped int
+	if err == nil {
+		applied, skipped, err = h.applyBatch(batch.Updates)
+	}
+	if err != nil {
+		h.rejectPost(r, err)
+		err.write(w)
+		return
+	}
+	writeData(w, batchResult{Applied: applied, Skipped: skipped, Track: h.view()})
+}
+
+func (h *Handler) apiArtHandler(w http.ResponseWriter, r *http.Request) {
+	if apiCORS(w, r, "GET") {
+		return
+	}
+	if r.Method != http.MethodGet {
+		errMethodNotAllowed.write(w)
+		return
+	}
+	data, ctype, err := h.albumArt(w, r)
+	if err != nil {
+		err.write(w)
+		return
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Cache-Control", "no-store, must-revalidate")
+	w.Write(data)
+}
+
+func (h *Handler) apiPaletteHandler(w http.ResponseWriter, r *http.Request) {
+	if apiCORS(w, r, "GET") {
+		return
+	}
+	palette, err := h.palette()
+	if err != nil {
+		err.write(w)
+		return
+	}
+	writeData(w, palette)
+}
+
+func (h *Handler) apiCardHandler(w http.ResponseWriter, r *http.Request) {
+	if apiCORS(w, r, "GET") {
+		return
+	}
+	data, err := h.card(r)
+	if err != nil {
+		err.write(w)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store, must-revalidate")
+	w.Write(data)
+}
+
+func (h *Handler) apiPrivacyHandler(w http.ResponseWriter, r *http.Request) {
+	if apiCORS(w, r, "GET, POST") {
+		return
+	}
+	action := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/v1/privacy"), "/")
+	if err := h.changePrivacy(w, r, action, true); err != nil {
+		err.write(w)
+		return
+	}
+	writeData(w, h.privacy.status())
+}
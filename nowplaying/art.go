@@ -0,0 +1,182 @@
+package nowplaying
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/StuxMirai/piff-music/googleimg"
+)
+
+// Sizes to ask Google image hosts for, best first. Anything larger is
+// wasted since the overlay and card resize locally.
+var artFetchSizes = []int{800, 544}
+
+// artLoader reads art from disk instead of the network. source is the
+// file URL recorded as the art's origin.
+type artLoader struct {
+	source string
+	load   func() (data []byte, contentType string, err error)
+}
+
+func (h *Handler) startArtUpdate(track NowPlaying, local *artLoader) {
+	h.artFetches.Add(1)
+	go func() {
+		defer h.artFetches.Done()
+		h.updateAlbumArt(track, local)
+	}()
+}
+
+// updateAlbumArt loads local art if there is any, then tries the track's
+// own URL, and finally falls back to a MusicBrainz / Cover Art Archive
+// lookup
+func (h *Handler) updateAlbumArt(track NowPlaying, local *artLoader) {
+	key := trackKey(track.Artist, track.SongName)
+	// The same art source publish asked for
+	requested := track.AlbumArtURL
+	if local != nil {
+		requested = local.source
+	}
+	if local != nil {
+		data, ctype, err := local.load()
+		if err == nil && len(data) == 0 {
+			err = errors.New("empty file")
+		}
+		h.health.noteArtFetch(local.source, err)
+		if err != nil {
+			h.metrics.artFetchFailures.Inc("local")
+			slog.Warn("local album art failed", "source", local.source, "err", err)
+		} else {
+			slog.Info("album art loaded", "source", local.source, "bytes", len(data))
+			h.store.setArt(requested, local.source, key, data, ctype)
+			return
+		}
+	}
+	if track.AlbumArtURL == "" && h.artFallback == nil {
+		return
+	}
+	release, err := h.artLimit.acquire(h.tenant)
+	if err != nil {
+		h.metrics.artFetchFailures.Inc("quota")
+		h.health.noteArtFetch(track.AlbumArtURL, err)
+		slog.Warn("album art skipped", "tenant", h.tenant, "err", err)
+		return
+	}
+	defer release()
+	if track.AlbumArtURL != "" && h.fetchAndCacheAlbumArt(requested, track.AlbumArtURL, "") {
+		return
+	}
+	if h.artFallback == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	u, ok := h.artFallback.Resolve(ctx, track.Artist, track.SongName)
+	if !ok {
+		h.metrics.artFetchFailures.Inc("no_match")
+		slog.Info("no fallback album art", "artist", track.Artist, "title", track.SongName)
+		h.health.noteArtFetch("musicbrainz:"+track.Artist+" - "+track.SongName, errors.New("no cover found"))
+		return
+	}
+	h.fetchAndCacheAlbumArt(requested, u, key)
+}
+
+// fetchAndCacheAlbumArt downloads src into the art cache. forTrack ties
+// art that didn't come from the track's own URL to that track; requested
+// is as for Store.setArt.
+func (h *Handler) fetchAndCacheAlbumArt(requested, src, forTrack string) bool {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	// Only Google-hosted art can be resized through the URL; everything
+	// else is fetched once and resized locally by /album-art?size=
+	candidates := []string{src}
+	if img, err := googleimg.Parse(src); err == nil {
+		candidates = img.Candidates(artFetchSizes...)
+		prober := &googleimg.Prober{Client: client, Header: artRequestHeader()}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if best, err := prober.Best(ctx, src, artFetchSizes...); err == nil {
+			candidates = []string{best}
+		}
+		cancel()
+	}
+
+	var lastErr error
+	for _, u := range candidates {
+		start := time.Now()
+		data, ctype, err := fetchArt(client, u)
+		h.metrics.artFetchDuration.Observe(time.Since(start).Seconds())
+		h.health.noteArtFetch(u, err)
+		if err != nil {
+			h.metrics.artFetchFailures.Inc(artFailureReason(err))
+			slog.Debug("album art candidate failed", "url", u, "err", err)
+			lastErr = err
+			continue
+		}
+		slog.Info("album art loaded", "url", u, "bytes", len(data), "content_type", ctype)
+		h.store.setArt(requested, src, forTrack, data, ctype)
+		return true
+	}
+	slog.Warn("album art fetch failed", "url", src, "err", lastErr)
+	return false
+}
+
+var (
+	errEmptyArt    = errors.New("empty response")
+	errArtTooLarge = fmt.Errorf("larger than %d MB", maxArtBytes>>20)
+)
+
+// maxArtBytes caps every art download and file, so one bad URL can't use
+// up the memory every tenant shares
+const maxArtBytes = 10 << 20
+
+// artStatusError is a non-200 response to an art request
+type artStatusError int
+
+func (e artStatusError) Error() string {
+	return fmt.Sprintf("HTTP %d %s", int(e), http.StatusText(int(e)))
+}
+
+func fetchArt(client *http.Client, u string) ([]byte, string, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header = artRequestHeader()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", artStatusError(resp.StatusCode)
+	}
+	if resp.ContentLength > maxArtBytes {
+		return nil, "", errArtTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxArtBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxArtBytes {
+		return nil, "", errArtTooLarge
+	}
+	if len(data) == 0 {
+		return nil, "", errEmptyArt
+	}
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+// Spoof headers to match browser context. Accept leaves out AVIF, which
+// can't be decoded here for the palette and the resized variants.
+func artRequestHeader() http.Header {
+	h := http.Header{}
+	h.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:141.0) Gecko/20100101 Firefox/141.0")
+	h.Set("Accept", "image/webp,image/png,image/jpeg,image/*;q=0.8,*/*;q=0.5")
+	h.Set("Referer", "https://music.youtube.com/")
+	return h
+}
@@ -0,0 +1,56 @@
+package nowplaying
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchArtLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		switch r.URL.Path {
+		case "/ok":
+			w.Write([]byte("jpeg"))
+		case "/exact":
+			w.Write(bytes.Repeat([]byte{1}, maxArtBytes))
+		case "/declared":
+			w.Header().Set("Content-Length", "104857600")
+			w.WriteHeader(http.StatusOK)
+		case "/streamed":
+			// No Content-Length, so only reading tells the size
+			chunk := bytes.Repeat([]byte{1}, 1<<20)
+			for i := 0; i <= maxArtBytes>>20; i++ {
+				if _, err := w.Write(chunk); err != nil {
+					return
+				}
+				w.(http.Flusher).Flush()
+			}
+		case "/empty":
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		path string
+		size int
+		err  error
+	}{
+		{"/ok", 4, nil},
+		{"/exact", maxArtBytes, nil},
+		{"/declared", 0, errArtTooLarge},
+		{"/streamed", 0, errArtTooLarge},
+		{"/empty", 0, errEmptyArt},
+	}
+	for _, tt := range tests {
+		data, _, err := fetchArt(srv.Client(), srv.URL+tt.path)
+		if !errors.Is(err, tt.err) || len(data) != tt.size {
+			t.Errorf("%s: got %d bytes, err %v; want %d bytes, err %v", tt.path, len(data), err, tt.size, tt.err)
+		}
+		if tt.err != nil && artFailureReason(err) == "other" {
+			t.Errorf("%s: no failure reason for %v", tt.path, err)
+		}
+	}
+}
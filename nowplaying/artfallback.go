@@ -0,0 +1,253 @@
+package nowplaying
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MusicBrainz asks anonymous clients for at most one request per second
+	musicBrainzInterval = time.Second
+	// Misses are retried eventually in case the cover gets uploaded
+	negativeArtTTL     = 6 * time.Hour
+	maxArtLookups      = 512
+	maxReleasesToCheck = 3
+	fallbackUserAgent  = "piff-music ( https://github.com/StuxMirai/piff-music )"
+)
+
+// ArtResolver finds cover art for a track by searching MusicBrainz for the
+// recording and taking the front cover of one of its releases from the
+// Cover Art Archive. Results, misses included, are cached per track.
+type ArtResolver struct {
+	musicBrainzURL string
+	coverArtURL    string
+	client         *http.Client
+
+	mu      sync.Mutex
+	lookups map[string]artLookup
+}
+
+// musicBrainzPace spaces MusicBrainz requests by musicBrainzInterval.
+// The limit is per client address, so every resolver in the process, one
+// per tenant, shares it.
+var musicBrainzPace struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+type artLookup struct {
+	url     string
+	at      time.Time
+	pending bool
+}
+
+func NewArtResolver(musicBrainzURL, coverArtURL string) *ArtResolver {
+	return &ArtResolver{
+		musicBrainzURL: strings.TrimRight(musicBrainzURL, "/"),
+		coverArtURL:    strings.TrimRight(coverArtURL, "/"),
+		client:         &http.Client{Timeout: 10 * time.Second},
+		lookups:        map[string]artLookup{},
+	}
+}
+
+// Resolve returns a front cover URL for the track. Concurrent calls for a
+// track already being looked up return immediately with ok false.
+func (r *ArtResolver) Resolve(ctx context.Context, artist, title string) (string, bool) {
+	key := trackKey(artist, title)
+	r.mu.Lock()
+	if l, ok := r.lookups[key]; ok && (l.pending || l.url != "" || time.Since(l.at) < negativeArtTTL) {
+		r.mu.Unlock()
+		return l.url, l.url != ""
+	}
+	r.lookups[key] = artLookup{pending: true}
+	r.mu.Unlock()
+
+	found, err := r.lookup(ctx, artist, title)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		// Network trouble is not a real miss; let the next call retry
+		delete(r.lookups, key)
+		return "", false
+	}
+	if len(r.lookups) >= maxArtLookups {
+		r.evictOldestLocked()
+	}
+	r.lookups[key] = artLookup{url: found, at: time.Now()}
+	return found, found != ""
+}
+
+func (r *ArtResolver) evictOldestLocked() {
+	var oldestKey string
+	var oldest time.Time
+	for k, l := range r.lookups {
+		if l.pending {
+			continue
+		}
+		if oldestKey == "" || l.at.Before(oldest) {
+			oldestKey, oldest = k, l.at
+		}
+	}
+	delete(r.lookups, oldestKey)
+}
+
+// lookup returns "" with a nil error when nothing was found
+func (r *ArtResolver) lookup(ctx context.Context, artist, title string) (string, error) {
+	releases, err := r.searchReleases(ctx, artist, title)
+	if err != nil {
+		return "", err
+	}
+	for i, id := range releases {
+		if i >= maxReleasesToCheck {
+			break
+		}
+		u, err := r.frontCover(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		if u != "" {
+			return u, nil
+		}
+	}
+	return "", nil
+}
+
+type mbRecordingSearch struct {
+	Recordings []struct {
+		Score    int `json:"score"`
+		Releases []struct {
+			ID           string `json:"id"`
+			Status       string `json:"status"`
+			ReleaseGroup struct {
+				PrimaryType string `json:"primary-type"`
+			} `json:"release-group"`
+		} `json:"releases"`
+	} `json:"recordings"`
+}
+
+// searchReleases returns release MBIDs for the best matching recordings,
+// official albums first
+func (r *ArtResolver) searchReleases(ctx context.Context, artist, title string) ([]string, error) {
+	q := fmt.Sprintf(`recording:"%s" AND artist:"%s"`, luceneEscape(title), luceneEscape(artist))
+	u := r.musicBrainzURL + "/ws/2/recording/?fmt=json&limit=5&query=" + url.QueryEscape(q)
+
+	if err := r.waitForMusicBrainz(ctx); err != nil {
+		return nil, err
+	}
+	var res mbRecordingSearch
+	if err := r.getJSON(ctx, u, &res); err != nil {
+		return nil, err
+	}
+
+	var preferred, rest []string
+	seen := map[string]bool{}
+	for _, rec := range res.Recordings {
+		if rec.Score < 80 {
+			continue
+		}
+		for _, rel := range rec.Releases {
+			if rel.ID == "" || seen[rel.ID] {
+				continue
+			}
+			seen[rel.ID] = true
+			if rel.Status == "Official" && rel.ReleaseGroup.PrimaryType == "Album" {
+				preferred = append(preferred, rel.ID)
+			} else {
+				rest = append(rest, rel.ID)
+			}
+		}
+	}
+	return append(preferred, rest...), nil
+}
+
+type caaRelease struct {
+	Images []struct {
+		Front      bool              `json:"front"`
+		Image      string            `json:"image"`
+		Thumbnails map[string]string `json:"thumbnails"`
+	} `json:"images"`
+}
+
+// frontCover returns "" with a nil error when the release has no front
+// cover in the archive
+func (r *ArtResolver) frontCover(ctx context.Context, releaseID string) (string, error) {
+	var res caaRelease
+	err := r.getJSON(ctx, r.coverArtURL+"/release/"+url.PathEscape(releaseID), &res)
+	if err == errNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, img := range res.Images {
+		if !img.Front {
+			continue
+		}
+		// 500px is plenty for the overlay and much smaller than the original
+		for _, size := range []string{"500", "large", "1200"} {
+			if u := img.Thumbnails[size]; u != "" {
+				return u, nil
+			}
+		}
+		return img.Image, nil
+	}
+	return "", nil
+}
+
+var errNotFound = errors.New("not found")
+
+func (r *ArtResolver) getJSON(ctx context.Context, u string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", fallbackUserAgent)
+	req.Header.Set("Accept", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	// Lookups are small; a huge answer is a broken or hostile server
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+func (r *ArtResolver) waitForMusicBrainz(ctx context.Context) error {
+	musicBrainzPace.mu.Lock()
+	now := time.Now()
+	at := musicBrainzPace.next
+	if at.Before(now) {
+		at = now
+	}
+	musicBrainzPace.next = at.Add(musicBrainzInterval)
+	musicBrainzPace.mu.Unlock()
+
+	t := time.NewTimer(time.Until(at))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// luceneEscape escapes characters that would end a quoted Lucene phrase
+func luceneEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
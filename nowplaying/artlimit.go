@@ -0,0 +1,112 @@
+package nowplaying
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// How long an art update waits for a download slot before giving up
+const artSlotWait = 30 * time.Second
+
+var (
+	errArtQuota    = errors.New("art update quota exceeded")
+	errArtSlotBusy = errors.New("no art download slot free")
+)
+
+// ArtLimiter shares album art downloads between the Handlers of several
+// tenants. Each tenant runs at most perTenant downloads of the total at
+// once and starts at most perMinute art updates a minute, so one busy
+// tenant can't starve the rest. Zero disables a limit.
+type ArtLimiter struct {
+	total     chan struct{}
+	perTenant int
+	perMinute int
+
+	mu      sync.Mutex
+	tenants map[string]*artQuota
+}
+
+type artQuota struct {
+	slots  chan struct{}
+	recent []time.Time
+}
+
+func NewArtLimiter(total, perTenant, perMinute int) *ArtLimiter {
+	l := &ArtLimiter{perTenant: perTenant, perMinute: perMinute, tenants: map[string]*artQuota{}}
+	if total > 0 {
+		l.total = make(chan struct{}, total)
+	}
+	return l
+}
+
+// WithArtLimiter makes the handler's art downloads count against l as
+// tenant
+func WithArtLimiter(l *ArtLimiter, tenant string) Option {
+	return func(h *Handler) { h.artLimit, h.tenant = l, tenant }
+}
+
+// acquire waits for a download slot for tenant. The returned func gives
+// it back. A nil limiter never waits.
+func (l *ArtLimiter) acquire(tenant string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	q, err := l.take(tenant, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(artSlotWait)
+	defer timer.Stop()
+	if q.slots != nil {
+		select {
+		case q.slots <- struct{}{}:
+		case <-timer.C:
+			return nil, errArtSlotBusy
+		}
+	}
+	if l.total != nil {
+		select {
+		case l.total <- struct{}{}:
+		case <-timer.C:
+			if q.slots != nil {
+				<-q.slots
+			}
+			return nil, errArtSlotBusy
+		}
+	}
+	return func() {
+		if l.total != nil {
+			<-l.total
+		}
+		if q.slots != nil {
+			<-q.slots
+		}
+	}, nil
+}
+
+// take counts an art update against tenant's per-minute quota
+func (l *ArtLimiter) take(tenant string, now time.Time) (*artQuota, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	q := l.tenants[tenant]
+	if q == nil {
+		q = &artQuota{}
+		if l.perTenant > 0 {
+			q.slots = make(chan struct{}, l.perTenant)
+		}
+		l.tenants[tenant] = q
+	}
+	kept := q.recent[:0]
+	for _, t := range q.recent {
+		if now.Sub(t) < time.Minute {
+			kept = append(kept, t)
+		}
+	}
+	q.recent = kept
+	if l.perMinute > 0 && len(q.recent) >= l.perMinute {
+		return nil, errArtQuota
+	}
+	q.recent = append(q.recent, now)
+	return q, nil
+}
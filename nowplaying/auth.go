@@ -0,0 +1,52 @@
+package nowplaying
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// WithToken requires token for anything that changes state and for the
+// admin pages. Clients send it as "Authorization: Bearer <token>" or as a
+// token query parameter, which is what the admin pages and URL-only tools
+// use. The overlay and its read-only APIs stay open for OBS.
+func WithToken(token string) Option {
+	return func(h *Handler) { h.token = token }
+}
+
+var errUnauthorized = newAPIError(http.StatusUnauthorized, "unauthorized", "Missing or wrong token")
+
+// needsToken reports whether r must carry the token. The privacy paths
+// with an action change state even on GET.
+func needsToken(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		return true
+	}
+	p := r.URL.Path
+	return p == "/admin" || strings.HasPrefix(p, "/admin/") || p == "/metrics" ||
+		strings.HasPrefix(p, "/api/privacy/") || strings.HasPrefix(p, "/api/v1/privacy/")
+}
+
+// authorized checks the token of r, when the handler has one
+func (h *Handler) authorized(r *http.Request) bool {
+	if h.token == "" || !needsToken(r) {
+		return true
+	}
+	got := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		got = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(h.token)) == 1
+}
+
+func (h *Handler) rejectUnauthorized(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("WWW-Authenticate", `Bearer realm="piff-music"`)
+	if strings.HasPrefix(r.URL.Path, "/api/v1/") || r.URL.Path == "/webhook" {
+		errUnauthorized.write(w)
+		return
+	}
+	errUnauthorized.writeLegacy(w)
+}
@@ -0,0 +1,135 @@
+package nowplaying
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const defaultBlockPlaceholder = "Music playing"
+
+// blocklistConfig is the JSON file format. Artists and titles match
+// whole values case-insensitively; patterns are regexes matched against
+// "Artist - Title".
+type blocklistConfig struct {
+	// Mode is "placeholder" (default) or "hide"
+	Mode        string   `json:"mode"`
+	Placeholder string   `json:"placeholder"`
+	Artists     []string `json:"artists"`
+	Titles      []string `json:"titles"`
+	Patterns    []string `json:"patterns"`
+}
+
+// Blocklist keeps tracks off the overlay, for DMCA reasons or explicit
+// titles
+type Blocklist struct {
+	hide        bool
+	placeholder string
+	artists     map[string]bool
+	// artistRuns are the blocked artists split into names, to find them
+	// within credits such as "A feat. B"
+	artistRuns [][]string
+	titles     map[string]bool
+	patterns   []*regexp.Regexp
+}
+
+func LoadBlocklist(path string) (*Blocklist, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var conf blocklistConfig
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	b := &Blocklist{
+		placeholder: conf.Placeholder,
+		artists:     map[string]bool{},
+		titles:      map[string]bool{},
+	}
+	switch conf.Mode {
+	case "", "placeholder":
+	case "hide":
+		b.hide = true
+	default:
+		return nil, fmt.Errorf("%s: unknown blocklist mode %q", path, conf.Mode)
+	}
+	if b.placeholder == "" {
+		b.placeholder = defaultBlockPlaceholder
+	}
+	for _, a := range conf.Artists {
+		b.artists[normalizeBlockValue(a)] = true
+		if names := artistNames(sanitizeText(a)); len(names) > 0 {
+			b.artistRuns = append(b.artistRuns, names)
+		}
+	}
+	for _, t := range conf.Titles {
+		b.titles[normalizeBlockValue(t)] = true
+	}
+	for i, p := range conf.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: pattern %d: %w", path, i, err)
+		}
+		b.patterns = append(b.patterns, re)
+	}
+	return b, nil
+}
+
+func normalizeBlockValue(s string) string {
+	return strings.ToLower(sanitizeText(s))
+}
+
+// Match reports why a track is blocked. Both the cleaned and the raw
+// values are checked, so cleanup can't let a blocked track slip through.
+func (b *Blocklist) Match(t NowPlaying) (string, bool) {
+	artists := append([]string{t.Artist, t.RawArtist}, t.FeaturedArtists...)
+	for _, a := range artists {
+		if a == "" {
+			continue
+		}
+		if b.artists[normalizeBlockValue(a)] {
+			return fmt.Sprintf("artist %q", a), true
+		}
+		names := artistNames(sanitizeText(a))
+		for _, run := range b.artistRuns {
+			if containsRun(names, run) {
+				return fmt.Sprintf("artist %q", a), true
+			}
+		}
+	}
+	for _, title := range []string{t.SongName, t.RawSongName} {
+		if title != "" && b.titles[normalizeBlockValue(title)] {
+			return fmt.Sprintf("title %q", title), true
+		}
+	}
+	for _, re := range b.patterns {
+		for _, s := range []string{t.Artist + " - " + t.SongName, t.RawArtist + " - " + t.RawSongName} {
+			if re.MatchString(s) {
+				return fmt.Sprintf("pattern %q", re.String()), true
+			}
+		}
+	}
+	return "", false
+}
+
+// Censor returns what the overlay shows in place of a blocked track.
+// Everything naming the track is cleared; the source and timing stay, so
+// arbitration still works and the progress bar keeps moving.
+func (b *Blocklist) Censor(t NowPlaying) NowPlaying {
+	out := t
+	out.SongName, out.Artist, out.Album, out.Year, out.Genre = "", "", "", "", ""
+	out.RawSongName, out.RawArtist, out.FeaturedArtists = "", "", nil
+	out.AlbumArtURL, out.VideoID = "", ""
+	out.Palette, out.cover = nil, nil
+	out.Blocked = true
+	if b.hide {
+		out.Hidden = true
+	} else {
+		out.SongName = b.placeholder
+	}
+	return out
+}
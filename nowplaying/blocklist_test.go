@@ -0,0 +1,71 @@
+package nowplaying
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testBlocklist(t *testing.T, conf string) *Blocklist {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "blocklist.json")
+	if err := os.WriteFile(path, []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := LoadBlocklist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestBlocklistCredits(t *testing.T) {
+	b := testBlocklist(t, `{"artists": ["Blocked", "Simon & Garfunkel"]}`)
+	tests := []struct {
+		artist string
+		want   bool
+	}{
+		{"blocked", true},
+		{"Other feat. Blocked", true},
+		{"Blocked & Other", true},
+		{"Other, Blocked", true},
+		{"Other (feat. Blocked)", true},
+		{"Blocked x Other", true},
+		{"Unblocked", false},
+		{"Blockedness", false},
+		{"Simon & Garfunkel, Other", true},
+		{"Simon", false},
+	}
+	for _, tt := range tests {
+		if _, got := b.Match(NowPlaying{SongName: "Song", Artist: tt.artist}); got != tt.want {
+			t.Errorf("Match(artist %q) = %v, want %v", tt.artist, got, tt.want)
+		}
+	}
+	if _, ok := b.Match(NowPlaying{SongName: "Song", Artist: "Other", RawArtist: "Other ft. Blocked"}); !ok {
+		t.Error("a blocked artist in the raw credit got through")
+	}
+}
+
+func TestBlocklistCensor(t *testing.T) {
+	in := NowPlaying{
+		SongName: "Song", Artist: "Artist", Album: "Album", Year: "2020", Genre: "Pop",
+		RawSongName: "Artist - Song", RawArtist: "ArtistVEVO", FeaturedArtists: []string{"Guest"},
+		AlbumArtURL: "https://example.com/a.jpg", VideoID: "abc",
+		CurrentSeconds: 10, EndSeconds: 200, CurrentTimestamp: "0:10", EndTimestamp: "3:20",
+		Source: "ytmusic-1",
+	}
+	want := NowPlaying{
+		SongName:       "Music playing",
+		CurrentSeconds: 10, EndSeconds: 200, CurrentTimestamp: "0:10", EndTimestamp: "3:20",
+		Source:  "ytmusic-1",
+		Blocked: true,
+	}
+	if got := testBlocklist(t, `{}`).Censor(in); !reflect.DeepEqual(got, want) {
+		t.Errorf("Censor =\n%+v\nwant\n%+v", got, want)
+	}
+	want.SongName, want.Hidden = "", true
+	if got := testBlocklist(t, `{"mode": "hide"}`).Censor(in); !reflect.DeepEqual(got, want) {
+		t.Errorf("Censor in hide mode =\n%+v\nwant\n%+v", got, want)
+	}
+}
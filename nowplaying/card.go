@@ -0,0 +1,349 @@
+package nowplaying
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	defaultCardWidth  = 900
+	defaultCardHeight = 300
+	minCardWidth      = 240
+	maxCardWidth      = 2400
+	minCardHeight     = 80
+	maxCardHeight     = 800
+	maxCardVariants   = 8
+)
+
+type cardTheme struct {
+	background color.RGBA
+	text       color.RGBA
+	subtext    color.RGBA
+	track      color.RGBA
+	// useArt draws the blurred album art behind the card
+	useArt bool
+}
+
+var cardThemes = map[string]cardTheme{
+	"art": {
+		background: color.RGBA{0, 0, 0, 255},
+		text:       color.RGBA{255, 255, 255, 255},
+		subtext:    color.RGBA{235, 235, 235, 255},
+		track:      color.RGBA{255, 255, 255, 90},
+		useArt:     true,
+	},
+	"dark": {
+		background: color.RGBA{20, 20, 24, 255},
+		text:       color.RGBA{255, 255, 255, 255},
+		subtext:    color.RGBA{190, 190, 200, 255},
+		track:      color.RGBA{255, 255, 255, 60},
+	},
+	"light": {
+		background: color.RGBA{244, 244, 246, 255},
+		text:       color.RGBA{17, 17, 17, 255},
+		subtext:    color.RGBA{80, 80, 90, 255},
+		track:      color.RGBA{0, 0, 0, 40},
+	},
+}
+
+type cardKey struct {
+	width, height int
+	theme         string
+}
+
+// cardState is everything the rendered card depends on. A new value
+// invalidates every cached card.
+type cardState struct {
+	song, artist string
+	current, end int
+	artVersion   int
+}
+
+// cardCache holds rendered cards for a single cardState
+type cardCache struct {
+	mu    sync.Mutex
+	state cardState
+	cards map[cardKey][]byte
+}
+
+var (
+	cardFontsOnce sync.Once
+	cardRegular   *opentype.Font
+	cardBold      *opentype.Font
+	cardFontsErr  error
+)
+
+func (h *Handler) cardHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := h.card(r)
+	if err != nil {
+		err.writeLegacy(w)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store, must-revalidate")
+	w.Write(data)
+}
+
+// card renders the card asked for by the query, or reuses a cached one
+func (h *Handler) card(r *http.Request) ([]byte, *apiError) {
+	q := r.URL.Query()
+	key := cardKey{
+		width:  clampParam(q.Get("width"), defaultCardWidth, minCardWidth, maxCardWidth),
+		height: clampParam(q.Get("height"), defaultCardHeight, minCardHeight, maxCardHeight),
+		theme:  q.Get("theme"),
+	}
+	// Text is sized from the height, so portrait cards don't work
+	key.height = min(key.height, key.width)
+	if _, ok := cardThemes[key.theme]; !ok {
+		key.theme = "art"
+	}
+
+	track := h.view()
+	cached := h.store.Art()
+	art, palette := cached.Data, cached.Palette
+	state := cardState{song: track.SongName, artist: track.Artist, artVersion: cached.Version}
+	if track.Hidden || (track.Idle != nil && track.Idle.Mode == IdleHidden) {
+		return nil, errNothingPlaying
+	}
+	if track.Idle != nil && track.Idle.Message != "" {
+		state.song = track.Idle.Message
+	}
+	if !artVisible(track) {
+		art, palette = nil, nil
+	}
+	state.current, state.end = trackSeconds(track)
+
+	cache := &h.cards
+	cache.mu.Lock()
+	if cache.cards == nil || cache.state != state {
+		cache.state = state
+		cache.cards = map[cardKey][]byte{}
+	}
+	data, ok := cache.cards[key]
+	cache.mu.Unlock()
+	if ok {
+		h.metrics.artCacheLookups.Inc("hit")
+	} else {
+		h.metrics.artCacheLookups.Inc("miss")
+	}
+
+	if !ok {
+		img, err := renderCard(key, state, art, palette)
+		if err != nil {
+			return nil, errInternal
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, errInternal
+		}
+		data = buf.Bytes()
+		cache.mu.Lock()
+		if cache.state == state && len(cache.cards) < maxCardVariants {
+			cache.cards[key] = data
+		}
+		cache.mu.Unlock()
+	}
+	return data, nil
+}
+
+func clampParam(raw string, def, lo, hi int) int {
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		return def
+	}
+	return min(max(n, lo), hi)
+}
+
+// trackSeconds prefers the numeric fields and falls back to parsing the
+// mm:ss timestamps
+func trackSeconds(t NowPlaying) (current, end int) {
+	current, end = t.CurrentSeconds, t.EndSeconds
+	if current <= 0 {
+		current = timestampToSeconds(t.CurrentTimestamp)
+	}
+	if end <= 0 {
+		end = timestampToSeconds(t.EndTimestamp)
+	}
+	return current, end
+}
+
+func timestampToSeconds(s string) int {
+	total := 0
+	for _, part := range strings.Split(strings.TrimSpace(s), ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0
+		}
+		total = total*60 + n
+	}
+	return total
+}
+
+func formatSeconds(s int) string {
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+func loadCardFonts() error {
+	cardFontsOnce.Do(func() {
+		cardRegular, cardFontsErr = opentype.Parse(goregular.TTF)
+		if cardFontsErr != nil {
+			return
+		}
+		cardBold, cardFontsErr = opentype.Parse(gobold.TTF)
+	})
+	return cardFontsErr
+}
+
+func renderCard(key cardKey, state cardState, art []byte, palette *Palette) (*image.RGBA, error) {
+	if err := loadCardFonts(); err != nil {
+		return nil, err
+	}
+	theme := cardThemes[key.theme]
+	w, h := key.width, key.height
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(theme.background), image.Point{}, draw.Src)
+
+	var cover *image.RGBA
+	if len(art) > 0 {
+		if decoded, _, err := decodeArt(art); err == nil {
+			cover = cropSquare(toRGBA(decoded))
+		}
+	}
+
+	if cover != nil && theme.useArt {
+		// Same look as the overlay: blurred, darkened art behind everything
+		side := max(w, h)
+		bg := gaussianBlur(resizeToFit(cover, 128), 4)
+		scaled := resizeTo(bg, side, side)
+		draw.Draw(img, img.Bounds(), scaled, image.Pt((side-w)/2, (side-h)/2), draw.Src)
+		draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{0, 0, 0, 110}), image.Point{}, draw.Over)
+	}
+
+	pad := h / 10
+	textX := pad
+	// Skip the thumbnail on tall, narrow cards where it would leave no
+	// room for the text
+	if side := h - 2*pad; cover != nil && w-side-3*pad >= w/3 {
+		thumb := resizeTo(cover, side, side)
+		draw.Draw(img, image.Rect(pad, pad, pad+side, pad+side), thumb, image.Point{}, draw.Src)
+		textX = pad*2 + side
+	}
+	textW := w - textX - pad
+
+	title, artist := state.song, state.artist
+	if title == "" {
+		title, artist = "Waiting for track...", ""
+	}
+
+	titleFace, err := opentype.NewFace(cardBold, &opentype.FaceOptions{Size: float64(h) * 0.16, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	defer titleFace.Close()
+	bodyFace, err := opentype.NewFace(cardRegular, &opentype.FaceOptions{Size: float64(h) * 0.11, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	defer bodyFace.Close()
+	smallFace, err := opentype.NewFace(cardRegular, &opentype.FaceOptions{Size: float64(h) * 0.075, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	defer smallFace.Close()
+
+	drawText(img, titleFace, theme.text, textX, pad+int(float64(h)*0.16), textW, title)
+	if artist != "" {
+		drawText(img, bodyFace, theme.subtext, textX, pad+int(float64(h)*0.33), textW, artist)
+	}
+
+	barH := max(4, h/25)
+	barY := h - pad - barH
+	bar := image.Rect(textX, barY, textX+textW, barY+barH)
+	draw.Draw(img, bar, image.NewUniform(theme.track), image.Point{}, draw.Over)
+	if state.end > 0 {
+		filled := textW * min(state.current, state.end) / state.end
+		fill := image.Rect(textX, barY, textX+filled, barY+barH)
+		drawProgressFill(img, fill, palette)
+	}
+
+	if state.end > 0 {
+		ts := formatSeconds(state.current) + " / " + formatSeconds(state.end)
+		tw := font.MeasureString(smallFace, ts).Ceil()
+		drawText(img, smallFace, theme.subtext, textX+textW-tw, barY-barH, textW, ts)
+	}
+	return img, nil
+}
+
+// drawProgressFill paints the filled part of the bar with the palette
+// gradient, or the overlay's default purple when there is no palette
+func drawProgressFill(img *image.RGBA, r image.Rectangle, palette *Palette) {
+	stops := []color.RGBA{{155, 89, 182, 255}, {142, 68, 173, 255}, {108, 92, 231, 255}}
+	if palette != nil {
+		stops = []color.RGBA{parseHexColor(palette.Base), parseHexColor(palette.Accent1), parseHexColor(palette.Accent2)}
+	}
+	width := r.Dx()
+	if width <= 0 {
+		return
+	}
+	for x := r.Min.X; x < r.Max.X; x++ {
+		t := float64(x-r.Min.X) / float64(max(1, width-1)) * float64(len(stops)-1)
+		i := min(int(t), len(stops)-2)
+		c := lerpColor(stops[i], stops[i+1], t-float64(i))
+		draw.Draw(img, image.Rect(x, r.Min.Y, x+1, r.Max.Y), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+}
+
+func lerpColor(a, b color.RGBA, t float64) color.RGBA {
+	mix := func(x, y uint8) uint8 { return uint8(float64(x) + (float64(y)-float64(x))*t) }
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 255}
+}
+
+func parseHexColor(s string) color.RGBA {
+	var r, g, b uint8
+	if _, err := fmt.Sscanf(s, "#%02x%02x%02x", &r, &g, &b); err != nil {
+		return color.RGBA{155, 89, 182, 255}
+	}
+	return color.RGBA{r, g, b, 255}
+}
+
+// drawText draws s with its baseline at y, cut with an ellipsis so it
+// fits in maxW pixels
+func drawText(img *image.RGBA, face font.Face, c color.RGBA, x, y, maxW int, s string) {
+	d := &font.Drawer{Dst: img, Src: image.NewUniform(c), Face: face}
+	s = fitText(d, s, maxW)
+	d.Dot = fixed.P(x, y)
+	d.DrawString(s)
+}
+
+func fitText(d *font.Drawer, s string, maxW int) string {
+	limit := fixed.I(maxW)
+	if d.MeasureString(s) <= limit {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		cut := strings.TrimRight(string(runes), " ") + "…"
+		if d.MeasureString(cut) <= limit {
+			return cut
+		}
+	}
+	return ""
+}
@@ -0,0 +1,233 @@
+package nowplaying
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Stages run in this order unless the rules file lists its own
+var defaultCleanupStages = []string{"sanitize", "byline", "topic", "decorations", "featured", "rules", "sanitize"}
+
+type cleanupRule struct {
+	// Field is "title", "artist" or "album"; empty applies to title and artist
+	Field   string `json:"field"`
+	Pattern string `json:"pattern"`
+	Replace string `json:"replace"`
+
+	re *regexp.Regexp
+}
+
+type cleanupConfig struct {
+	Stages []string      `json:"stages"`
+	Rules  []cleanupRule `json:"rules"`
+}
+
+type CleanupPipeline struct {
+	stages []func(*NowPlaying)
+}
+
+var (
+	bylineSep  = regexp.MustCompile(`\s+[•·]\s+`)
+	bylineYear = regexp.MustCompile(`^\d{4}$`)
+	// Video bylines carry counters instead of album and year
+	bylineCounter = regexp.MustCompile(`(?i)^[\d.,]+\s*[kmb]?\s+(views|likes|plays|subscribers|watching)$`)
+
+	topicSuffix = regexp.MustCompile(`(?i)\s+-\s+topic$`)
+
+	// "(Official Video)", "[Lyrics]", "(Official Music Video)", "(Audio)", "[HD]", ...
+	decorationRules = mustCompileRules([]cleanupRule{
+		{Field: "title", Pattern: `(?i)\s*[\(\[]\s*(?:official\s+)?(?:(?:music|lyric|lyrics|audio|hd|4k)\s+)?(?:video|audio|lyrics?|visuali[sz]er|m/?v|hd|4k|hq)\s*[\)\]]`},
+	})
+
+	featBracketed   = regexp.MustCompile(`(?i)\s*[\(\[]\s*(?:feat\.?|ft\.?|featuring|with)\s+([^\)\]]+)[\)\]]`)
+	featUnbracketed = regexp.MustCompile(`(?i)\s+(?:feat\.?|ft\.?|featuring)\s+(.+)$`)
+	featSplit       = regexp.MustCompile(`\s*(?:,|\s&\s|\s+and\s+)\s*`)
+)
+
+// LoadCleanupPipeline builds the pipeline from a JSON rules file, or the
+// default stages when path is empty
+func LoadCleanupPipeline(path string) (*CleanupPipeline, error) {
+	conf := cleanupConfig{Stages: defaultCleanupStages}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &conf); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if conf.Stages == nil {
+			conf.Stages = defaultCleanupStages
+		}
+	}
+	if err := compileRules(conf.Rules); err != nil {
+		return nil, err
+	}
+
+	p := &CleanupPipeline{}
+	for _, name := range conf.Stages {
+		switch name {
+		case "sanitize":
+			p.stages = append(p.stages, sanitizeStage)
+		case "byline":
+			p.stages = append(p.stages, bylineStage)
+		case "topic":
+			p.stages = append(p.stages, topicStage)
+		case "decorations":
+			p.stages = append(p.stages, rulesStage(decorationRules))
+		case "featured":
+			p.stages = append(p.stages, featuredStage)
+		case "rules":
+			p.stages = append(p.stages, rulesStage(conf.Rules))
+		default:
+			return nil, fmt.Errorf("unknown cleanup stage %q", name)
+		}
+	}
+	return p, nil
+}
+
+func mustCompileRules(rules []cleanupRule) []cleanupRule {
+	if err := compileRules(rules); err != nil {
+		panic(err)
+	}
+	return rules
+}
+
+func compileRules(rules []cleanupRule) error {
+	for i := range rules {
+		switch rules[i].Field {
+		case "", "title", "artist", "album":
+		default:
+			return fmt.Errorf("cleanup rule %d: unknown field %q", i, rules[i].Field)
+		}
+		re, err := regexp.Compile(rules[i].Pattern)
+		if err != nil {
+			return fmt.Errorf("cleanup rule %d: %w", i, err)
+		}
+		rules[i].re = re
+	}
+	return nil
+}
+
+// Apply keeps the incoming title and artist in the Raw fields and cleans
+// the display ones. A stage that empties the title is undone, since a
+// blank overlay is worse than a noisy one.
+func (p *CleanupPipeline) Apply(t *NowPlaying) {
+	if t.RawSongName == "" {
+		t.RawSongName = t.SongName
+	}
+	if t.RawArtist == "" {
+		t.RawArtist = t.Artist
+	}
+	for _, stage := range p.stages {
+		before := *t
+		stage(t)
+		if strings.TrimSpace(t.SongName) == "" || strings.TrimSpace(t.Artist) == "" {
+			*t = before
+		}
+	}
+}
+
+func sanitizeStage(t *NowPlaying) {
+	t.SongName = sanitizeText(t.SongName)
+	t.Artist = sanitizeText(t.Artist)
+	t.Album = sanitizeText(t.Album)
+}
+
+// sanitizeText drops control and invisible formatting characters and
+// collapses runs of whitespace
+func sanitizeText(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\u200d':
+			// zero width joiner holds emoji sequences together
+			return r
+		case unicode.IsSpace(r):
+			return ' '
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r):
+			return -1
+		}
+		return r
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// bylineStage splits "Artist • Album • Year" into its fields
+func bylineStage(t *NowPlaying) {
+	parts := bylineSep.Split(t.Artist, -1)
+	if len(parts) < 2 {
+		return
+	}
+	t.Artist = parts[0]
+	for _, p := range parts[1:] {
+		switch {
+		case bylineYear.MatchString(p):
+			if t.Year == "" {
+				t.Year = p
+			}
+		case bylineCounter.MatchString(p):
+		default:
+			if t.Album == "" {
+				t.Album = p
+			}
+		}
+	}
+}
+
+func topicStage(t *NowPlaying) {
+	t.Artist = topicSuffix.ReplaceAllString(t.Artist, "")
+}
+
+// featuredStage moves "feat. X" out of the title and artist
+func featuredStage(t *NowPlaying) {
+	var found []string
+	if m := featBracketed.FindStringSubmatch(t.SongName); m != nil {
+		found = append(found, m[1])
+		t.SongName = featBracketed.ReplaceAllString(t.SongName, "")
+	} else if m := featUnbracketed.FindStringSubmatch(t.SongName); m != nil {
+		found = append(found, m[1])
+		t.SongName = featUnbracketed.ReplaceAllString(t.SongName, "")
+	}
+	if m := featUnbracketed.FindStringSubmatch(t.Artist); m != nil {
+		found = append(found, m[1])
+		t.Artist = featUnbracketed.ReplaceAllString(t.Artist, "")
+	}
+	for _, f := range found {
+		for _, name := range featSplit.Split(f, -1) {
+			if name = strings.TrimSpace(name); name != "" && !containsFold(t.FeaturedArtists, name) {
+				t.FeaturedArtists = append(t.FeaturedArtists, name)
+			}
+		}
+	}
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func rulesStage(rules []cleanupRule) func(*NowPlaying) {
+	return func(t *NowPlaying) {
+		for _, r := range rules {
+			switch r.Field {
+			case "title":
+				t.SongName = r.re.ReplaceAllString(t.SongName, r.Replace)
+			case "artist":
+				t.Artist = r.re.ReplaceAllString(t.Artist, r.Replace)
+			case "album":
+				t.Album = r.re.ReplaceAllString(t.Album, r.Replace)
+			default:
+				t.SongName = r.re.ReplaceAllString(t.SongName, r.Replace)
+				t.Artist = r.re.ReplaceAllString(t.Artist, r.Replace)
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package nowplaying
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Only the most recent events are kept; this is a notice board, not a log
+const maxAdminEvents = 200
+
+type adminEvent struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Message string    `json:"message"`
+}
+
+// eventLog keeps recent notable events for the admin pages
+type eventLog struct {
+	mu     sync.Mutex
+	events []adminEvent
+}
+
+// record logs a message and keeps it for the admin pages
+func (l *eventLog) record(kind, format string, args ...any) {
+	e := adminEvent{Time: time.Now(), Kind: kind, Message: fmt.Sprintf(format, args...)}
+	slog.Info(e.Message, "event", kind)
+
+	l.mu.Lock()
+	l.events = append(l.events, e)
+	if len(l.events) > maxAdminEvents {
+		l.events = l.events[len(l.events)-maxAdminEvents:]
+	}
+	l.mu.Unlock()
+}
+
+// recent returns events newest first
+func (l *eventLog) recent() []adminEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]adminEvent, len(l.events))
+	for i, e := range l.events {
+		out[len(out)-1-i] = e
+	}
+	return out
+}
+
+func (h *Handler) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.events.recent())
+}
@@ -0,0 +1,431 @@
+package nowplaying
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Handler serves the overlay, its JSON APIs and the admin pages for a
+// Store, and feeds the Store from the webhook and any Sources it runs.
+type Handler struct {
+	store *Store
+	mux   *http.ServeMux
+
+	cleanup     *CleanupPipeline
+	artFallback *ArtResolver
+	overrides   *OverrideStore
+	blocklist   *Blocklist
+	mask        *WordMask
+	library     *MusicLibrary
+	idleMode    IdleMode
+	idleMessage string
+	staleAfter  time.Duration
+	sinks       []Sink
+	token       string
+	artLimit    *ArtLimiter
+	tenant      string
+
+	privacy  privacyMode
+	events   eventLog
+	health   *healthMonitor
+	metrics  *metrics
+	variants artVariantCache
+	cards    cardCache
+
+	// artFetches tracks running art updates so Wait can let them finish
+	artFetches sync.WaitGroup
+
+	blockedMu sync.Mutex
+	// lastBlocked keeps one alert per blocked track rather than one per post
+	lastBlocked string
+
+	// postMu serializes incoming tracks and guards sources
+	postMu  sync.Mutex
+	sources sourceArbiter
+
+	sinkStops []func()
+	sinkWG    sync.WaitGroup
+}
+
+// Option configures a Handler. Every feature is off unless its option is
+// given.
+type Option func(*Handler)
+
+// WithCleanup cleans up titles and artists of incoming tracks
+func WithCleanup(p *CleanupPipeline) Option {
+	return func(h *Handler) { h.cleanup = p }
+}
+
+// WithArtResolver looks up art for tracks that come without any
+func WithArtResolver(r *ArtResolver) Option {
+	return func(h *Handler) { h.artFallback = r }
+}
+
+// WithOverrides applies per-track overrides. /admin/overrides, which
+// edits them, is only served with WithToken.
+func WithOverrides(s *OverrideStore) Option {
+	return func(h *Handler) { h.overrides = s }
+}
+
+func WithBlocklist(b *Blocklist) Option {
+	return func(h *Handler) { h.blocklist = b }
+}
+
+// WithWordMask masks listed words in displayed titles and artists
+func WithWordMask(m *WordMask) Option {
+	return func(h *Handler) { h.mask = m }
+}
+
+// WithLibrary fills in metadata and art from local files. Scanning is up
+// to the caller, see MusicLibrary.Watch.
+func WithLibrary(l *MusicLibrary) Option {
+	return func(h *Handler) { h.library = l }
+}
+
+// WithIdleScreen sets what the overlay shows when nothing is playing or
+// no track was posted for staleAfter; 0 never goes stale. The default is
+// the "Waiting for track..." message after 30 seconds.
+func WithIdleScreen(mode IdleMode, message string, staleAfter time.Duration) Option {
+	return func(h *Handler) {
+		h.idleMode, h.idleMessage, h.staleAfter = mode, message, staleAfter
+	}
+}
+
+// WithSinks delivers every new track to the given sinks
+func WithSinks(sinks ...Sink) Option {
+	return func(h *Handler) { h.sinks = append(h.sinks, sinks...) }
+}
+
+func NewHandler(store *Store, opts ...Option) *Handler {
+	h := &Handler{
+		store:       store,
+		mux:         http.NewServeMux(),
+		idleMode:    IdleMessage,
+		idleMessage: defaultIdleMessage,
+		staleAfter:  30 * time.Second,
+		sources:     sourceArbiter{rule: SourceRecent},
+		health:      newHealthMonitor(),
+		metrics:     newMetrics(),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.privacy.store = store
+	h.privacy.events = &h.events
+	h.variants.lookups = h.metrics.artCacheLookups
+
+	h.registerAPI()
+	// The pre-v1 paths, kept for existing overlays and scripts
+	h.mux.HandleFunc("/webhook", countStatus(h.metrics.webhookRequests, h.webhookHandler))
+	h.mux.HandleFunc("/", h.indexHandler)
+	h.mux.HandleFunc("/now-playing", h.nowPlayingHandler)
+	h.mux.HandleFunc("/album-art", h.albumArtHandler)
+	h.mux.HandleFunc("/palette", h.paletteHandler)
+	h.mux.HandleFunc("/card.png", h.cardHandler)
+	h.mux.HandleFunc("/library/search", h.librarySearchHandler)
+	h.mux.HandleFunc("/api/privacy", h.privacyHandler)
+	h.mux.HandleFunc("/api/privacy/", h.privacyHandler)
+	h.mux.HandleFunc("/metrics", h.metricsHandler)
+	h.mux.HandleFunc("/admin", h.adminPageHandler)
+	h.mux.HandleFunc("/admin/{$}", h.adminPageHandler)
+	h.mux.HandleFunc("/admin/status", h.adminStatusHandler)
+	h.mux.HandleFunc("/admin/events", h.eventsHandler)
+	if h.overridesPage() {
+		h.mux.HandleFunc("/admin/overrides", h.overridesPageHandler)
+		h.mux.HandleFunc("/admin/overrides/api", h.overridesAPIHandler)
+	}
+
+	for _, s := range h.sinks {
+		h.startSink(s)
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		h.rejectUnauthorized(w, r)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// RunSource feeds every track src emits into the handler until ctx is
+// done or the source ends
+func (h *Handler) RunSource(ctx context.Context, src Source) error {
+	return src.Run(ctx, func(t NowPlaying) {
+		if t.SongName == "" && t.Artist == "" {
+			h.stopSource(sourceID(t.Source))
+			return
+		}
+		h.Ingest(t)
+	})
+}
+
+// Wait blocks until running album art fetches are done, so the art they
+// bring in makes it into a saved Store
+func (h *Handler) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		h.artFetches.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops delivering tracks to sinks and waits for deliveries in
+// progress
+func (h *Handler) Close() error {
+	for _, stop := range h.sinkStops {
+		stop()
+	}
+	h.sinkWG.Wait()
+	return nil
+}
+
+func (h *Handler) webhookHandler(w http.ResponseWriter, r *http.Request) {
+	// CORS preflight
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.health.notePost(r)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	u, err := h.readTrack(w, r, false)
+	if err == nil {
+		_, err = h.applyTrack(u)
+	}
+	if err != nil {
+		h.rejectPost(r, err)
+		err.write(w)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Ingest cleans up an incoming track and makes it current, unless privacy
+// mode is on or another source is active. t.Source names the source, the
+// default one when empty. Tracks without a title or artist are ignored.
+// The cleaned track is kept for the overrides page.
+func (h *Handler) Ingest(t NowPlaying) {
+	h.postMu.Lock()
+	defer h.postMu.Unlock()
+	h.ingestFrom(h.sources.get(sourceID(t.Source)), t, time.Now(), true)
+}
+
+// ingestAt ingests a track the source saw at the given time. Without
+// fetchArt the art source is left alone, for replayed tracks that are
+// already over.
+func (h *Handler) ingestAt(t NowPlaying, at time.Time, fetchArt bool) bool {
+	if t.SongName == "" || t.Artist == "" {
+		h.health.noteIgnored()
+		return false
+	}
+	t.ProgressPct = 0
+	h.store.touchAt(at)
+	if h.privacy.holds(t) {
+		return false
+	}
+	if h.cleanup != nil {
+		h.cleanup.Apply(&t)
+	}
+	h.store.setLastIncoming(t)
+	h.publish(t, fetchArt)
+	return true
+}
+
+// publish applies the blocklist, overrides and library metadata, makes
+// the track current and starts an art update if the art source changed
+// and fetchArt is set
+func (h *Handler) publish(t NowPlaying, fetchArt bool) {
+	if h.privacy.active() {
+		return
+	}
+	if h.blocklist != nil {
+		if reason, ok := h.blocklist.Match(t); ok {
+			h.noteBlocked(t, reason)
+			h.store.setTrack(h.blocklist.Censor(t))
+			return
+		}
+		h.noteBlocked(NowPlaying{}, "")
+	}
+
+	var local *artLoader
+	if h.overrides != nil {
+		local = h.overrides.Apply(&t)
+	}
+	if local == nil {
+		local = t.cover
+	}
+	t.cover = nil
+	// Local files win over the source's art URL
+	if entry, ok := h.enrichFromLibrary(&t); ok && entry.HasArt && local == nil {
+		local = libraryArtLoader(entry)
+	}
+	artSource := t.AlbumArtURL
+	if local != nil {
+		artSource = local.source
+	}
+	if !fetchArt {
+		artSource = ""
+	}
+
+	changed, needsArt := h.store.publish(t, artSource)
+	if changed {
+		h.metrics.trackChanges.Inc("")
+		slog.Info("track changed", "artist", t.Artist, "title", t.SongName, "album", t.Album, "video_id", t.VideoID)
+	}
+	if fetchArt && changed {
+		// Counted per track rather than per post, which would bury misses
+		// under the repeats of a playing track
+		if needsArt {
+			h.metrics.artDownloadCache.Inc("miss")
+		} else {
+			h.metrics.artDownloadCache.Inc("hit")
+		}
+	}
+	if fetchArt && needsArt {
+		h.startArtUpdate(t, local)
+	}
+}
+
+// noteBlocked raises an admin alert the first time a track is blocked.
+// A zero track lets the next blocked track raise an alert again, even
+// when it is the same one as before.
+func (h *Handler) noteBlocked(t NowPlaying, reason string) {
+	key := ""
+	if reason != "" {
+		key = trackKey(t.RawArtist, t.RawSongName)
+	}
+	h.blockedMu.Lock()
+	first := key != h.lastBlocked
+	h.lastBlocked = key
+	h.blockedMu.Unlock()
+	if first && reason != "" {
+		h.events.record("blocked", "%s - %s matched %s", t.Artist, t.SongName, reason)
+	}
+}
+
+func (h *Handler) nowPlayingHandler(w http.ResponseWriter, r *http.Request) {
+	h.health.noteClient(r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.view())
+}
+
+// view is the current track as the overlay should show it, including the
+// idle screen once the source has gone quiet
+func (h *Handler) view() NowPlaying {
+	h.store.mu.RLock()
+	out := h.store.track
+	art := h.store.art
+	lastUpdate := h.store.lastUpdate
+	stopped := h.store.stopped
+	h.store.mu.RUnlock()
+
+	// Include current art version so client can bust cache
+	out.AlbumArtVersion = art.Version
+	if artVisible(out) {
+		out.Palette = art.Palette
+	}
+	// Fallback art has no URL from the source; report where it came from
+	if out.AlbumArtURL == "" && art.Track != "" && art.Track == trackKey(out.Artist, out.SongName) {
+		out.AlbumArtURL = art.URL
+	}
+	out.ProgressPct = 0
+	if current, end := trackSeconds(out); end > 0 {
+		out.ProgressPct = math.Round(math.Min(100, float64(current)*100/float64(end))*10) / 10
+	}
+	out.State, out.Idle, out.LastUpdate = statePlaying, nil, nil
+	if !lastUpdate.IsZero() {
+		updated := lastUpdate
+		out.LastUpdate = &updated
+	}
+	if h.privacy.active() || stopped || h.isStale(lastUpdate, time.Now()) || (out.SongName == "" && !out.Hidden) {
+		updated := out.LastUpdate
+		out = h.idleView(out)
+		out.LastUpdate = updated
+	}
+	return h.display(out)
+}
+
+func (h *Handler) paletteHandler(w http.ResponseWriter, r *http.Request) {
+	palette, err := h.palette()
+	if err != nil {
+		err.writeLegacy(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(palette)
+}
+
+func (h *Handler) palette() (*Palette, *apiError) {
+	palette := h.store.Art().Palette
+	if palette == nil || !artVisible(h.store.Track()) {
+		return nil, errNothingPlaying
+	}
+	return palette, nil
+}
+
+func (h *Handler) albumArtHandler(w http.ResponseWriter, r *http.Request) {
+	data, ctype, err := h.albumArt(w, r)
+	if err != nil {
+		err.writeLegacy(w)
+		return
+	}
+	w.Header().Set("Content-Type", ctype)
+	// Ensure the browser refetches when version changes via query param
+	w.Header().Set("Cache-Control", "no-store, must-revalidate")
+	w.Write(data)
+}
+
+// albumArt returns the cached art, resized and blurred as the query asks.
+// Art that can't be decoded is served as it is, marked with an
+// X-Art-Variant: original header so the overlay can blur it itself.
+func (h *Handler) albumArt(w http.ResponseWriter, r *http.Request) ([]byte, string, *apiError) {
+	art := h.store.Art()
+	bytes, ctype := art.Data, art.ContentType
+	if len(bytes) == 0 || !artVisible(h.store.Track()) {
+		return nil, "", errNothingPlaying
+	}
+	if key, ok := parseArtVariantParams(r.URL.Query().Get("size"), r.URL.Query().Get("blur")); ok {
+		variant, err := h.variants.get(art.Version, bytes, key)
+		if err != nil {
+			slog.Debug("serving album art unchanged", "content_type", ctype, "err", err)
+			w.Header().Set("X-Art-Variant", "original")
+		} else {
+			bytes = variant.data
+			ctype = variant.contentType
+		}
+	}
+	if ctype == "" {
+		ctype = "image/jpeg"
+	}
+	return bytes, ctype, nil
+}
+
+// artVisible reports whether the cached art may be shown alongside t. It
+// still belongs to the previous track while a blocked track plays. Privacy
+// mode publishes an empty track when it starts, which hides the art until
+// the next track after it ends.
+func artVisible(t NowPlaying) bool {
+	return t.SongName != "" && !t.Blocked
+}
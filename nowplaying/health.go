@@ -0,0 +1,421 @@
+package nowplaying
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Overlays poll every second; one that hasn't for this long is gone
+	clientTimeout   = 10 * time.Second
+	maxArtFetchLog  = 25
+	postRateWindow  = time.Minute
+	maxTrackedPosts = 600
+)
+
+type artFetchRecord struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	OK     bool      `json:"ok"`
+	Error  string    `json:"error,omitempty"`
+}
+
+type overlayClient struct {
+	Address   string    `json:"address"`
+	UserAgent string    `json:"user_agent"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// healthMonitor collects what the admin page needs to answer "why isn't
+// it working": is the add-on posting, are posts valid, does art load and
+// is anything displaying the overlay
+type healthMonitor struct {
+	mu      sync.Mutex
+	started time.Time
+
+	lastPost      time.Time
+	lastOrigin    string
+	lastUserAgent string
+	lastRemote    string
+	totalPosts    int
+	recentPosts   []time.Time
+	ignoredPosts  int
+
+	invalidPosts  int
+	lastInvalid   string
+	lastInvalidAt time.Time
+
+	artAttempts int
+	artFailures int
+	artLog      []artFetchRecord
+
+	clients map[string]overlayClient
+}
+
+func newHealthMonitor() *healthMonitor {
+	return &healthMonitor{started: time.Now(), clients: map[string]overlayClient{}}
+}
+
+func (h *healthMonitor) notePost(r *http.Request) {
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastPost = now
+	h.lastOrigin = r.Header.Get("Origin")
+	h.lastUserAgent = r.UserAgent()
+	h.lastRemote = r.RemoteAddr
+	h.totalPosts++
+	h.recentPosts = append(h.recentPosts, now)
+	if len(h.recentPosts) > maxTrackedPosts {
+		h.recentPosts = h.recentPosts[len(h.recentPosts)-maxTrackedPosts:]
+	}
+}
+
+// noteIgnored counts posts without a title or artist, which the add-on
+// sends while nothing is playing
+func (h *healthMonitor) noteIgnored() {
+	h.mu.Lock()
+	h.ignoredPosts++
+	h.mu.Unlock()
+}
+
+func (h *healthMonitor) noteInvalid(reason string) {
+	h.mu.Lock()
+	h.invalidPosts++
+	h.lastInvalid = reason
+	h.lastInvalidAt = time.Now()
+	h.mu.Unlock()
+}
+
+// noteArtFetch records the outcome of loading art from src; err nil
+// means it worked
+func (h *healthMonitor) noteArtFetch(src string, err error) {
+	rec := artFetchRecord{Time: time.Now(), Source: src, OK: err == nil}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.artAttempts++
+	if err != nil {
+		h.artFailures++
+	}
+	h.artLog = append(h.artLog, rec)
+	if len(h.artLog) > maxArtFetchLog {
+		h.artLog = h.artLog[len(h.artLog)-maxArtFetchLog:]
+	}
+}
+
+// noteClient marks an overlay as connected; overlays are told apart by
+// address and user agent since they carry no other identity
+func (h *healthMonitor) noteClient(r *http.Request) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	c := overlayClient{Address: host, UserAgent: r.UserAgent(), LastSeen: time.Now()}
+	h.mu.Lock()
+	h.clients[c.Address+"\x00"+c.UserAgent] = c
+	h.pruneClientsLocked(c.LastSeen)
+	h.mu.Unlock()
+}
+
+// pruneClientsLocked forgets overlays that went quiet. It runs on every
+// poll as well as for the status page, so rotating addresses or user
+// agents can't grow the map while nobody looks at the page.
+func (h *healthMonitor) pruneClientsLocked(now time.Time) {
+	for key, c := range h.clients {
+		if now.Sub(c.LastSeen) > clientTimeout {
+			delete(h.clients, key)
+		}
+	}
+}
+
+type sourceStatus struct {
+	LastPost       *time.Time `json:"last_post,omitempty"`
+	Origin         string     `json:"origin,omitempty"`
+	UserAgent      string     `json:"user_agent,omitempty"`
+	RemoteAddr     string     `json:"remote_addr,omitempty"`
+	PostsTotal     int        `json:"posts_total"`
+	PostsPerMinute int        `json:"posts_per_minute"`
+	IgnoredPosts   int        `json:"ignored_posts"`
+	InvalidPosts   int        `json:"invalid_posts"`
+	LastInvalid    string     `json:"last_invalid,omitempty"`
+	LastInvalidAt  *time.Time `json:"last_invalid_at,omitempty"`
+}
+
+type artStatus struct {
+	Attempts int              `json:"attempts"`
+	Failures int              `json:"failures"`
+	Recent   []artFetchRecord `json:"recent"`
+}
+
+type adminStatus struct {
+	Started       time.Time       `json:"started"`
+	UptimeSeconds int             `json:"uptime_seconds"`
+	Source        sourceStatus    `json:"source"`
+	Art           artStatus       `json:"art"`
+	Clients       []overlayClient `json:"clients"`
+	Track         NowPlaying      `json:"track"`
+	Sources       sourcesStatus   `json:"sources"`
+	Privacy       privacyStatus   `json:"privacy"`
+	Events        []adminEvent    `json:"events"`
+}
+
+// status fills in everything but the track, privacy and events
+func (h *healthMonitor) status() adminStatus {
+	now := time.Now()
+	h.mu.Lock()
+	s := adminStatus{
+		Started:       h.started,
+		UptimeSeconds: int(now.Sub(h.started).Seconds()),
+		Source: sourceStatus{
+			Origin:       h.lastOrigin,
+			UserAgent:    h.lastUserAgent,
+			RemoteAddr:   h.lastRemote,
+			PostsTotal:   h.totalPosts,
+			IgnoredPosts: h.ignoredPosts,
+			InvalidPosts: h.invalidPosts,
+			LastInvalid:  h.lastInvalid,
+		},
+		Art:     artStatus{Attempts: h.artAttempts, Failures: h.artFailures, Recent: []artFetchRecord{}},
+		Clients: []overlayClient{},
+	}
+	if !h.lastPost.IsZero() {
+		t := h.lastPost
+		s.Source.LastPost = &t
+	}
+	if !h.lastInvalidAt.IsZero() {
+		t := h.lastInvalidAt
+		s.Source.LastInvalidAt = &t
+	}
+	for _, t := range h.recentPosts {
+		if now.Sub(t) <= postRateWindow {
+			s.Source.PostsPerMinute++
+		}
+	}
+	for i := len(h.artLog) - 1; i >= 0; i-- {
+		s.Art.Recent = append(s.Art.Recent, h.artLog[i])
+	}
+	h.pruneClientsLocked(now)
+	for _, c := range h.clients {
+		s.Clients = append(s.Clients, c)
+	}
+	h.mu.Unlock()
+	return s
+}
+
+func (h *Handler) adminStatus() adminStatus {
+	s := h.health.status()
+	s.Track = h.view()
+	s.Sources = h.sourcesStatus()
+	s.Privacy = h.privacy.status()
+	s.Events = h.events.recent()
+	if len(s.Events) > 20 {
+		s.Events = s.Events[:20]
+	}
+	return s
+}
+
+func (h *Handler) adminStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.adminStatus())
+}
+
+func (h *Handler) adminPageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/admin/" {
+		// The page's links are relative to /admin. The redirect is relative
+		// too, since under a tenant the path seen here lacks its prefix.
+		target := "../admin"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		w.Header().Set("Location", target)
+		w.WriteHeader(http.StatusMovedPermanently)
+		return
+	}
+	if r.URL.Path != "/admin" {
+		http.NotFound(w, r)
+		return
+	}
+	page := adminPageHTML
+	if !h.overridesPage() {
+		page = strings.Replace(page, overridesLinkHTML, "", 1)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
+
+// overridesLinkHTML is left out of the admin page when the overrides page
+// isn't served
+const overridesLinkHTML = ` <a id="overridesLink" href="admin/overrides">Track overrides</a>`
+
+const adminPageHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>Now Playing Status</title>
+    <style>
+        body { font-family: system-ui, -apple-system, Segoe UI, Roboto, Helvetica, Arial, sans-serif; background: #15151a; color: #eee; margin: 24px; }
+        h1 { font-size: 1.4rem; }
+        h2 { font-size: 1.1rem; margin-top: 28px; }
+        table { border-collapse: collapse; width: 100%; }
+        th, td { text-align: left; padding: 6px 8px; border-bottom: 1px solid #333; font-size: 0.9rem; vertical-align: top; }
+        th { width: 200px; color: #aaa; font-weight: normal; }
+        a { color: #a29bfe; }
+        .ok { color: #55efc4; }
+        .bad { color: #ff7675; }
+        .muted { color: #999; }
+        .src { word-break: break-all; }
+        button { background: #2d2d38; color: #eee; border: 1px solid #444; border-radius: 4px; padding: 2px 10px; cursor: pointer; }
+    </style>
+</head>
+<body>
+    <h1>Now Playing Status</h1>
+    <p class="muted">Refreshes every 2 seconds.` + overridesLinkHTML + `</p>
+
+    <h2>Source</h2>
+    <table id="source"></table>
+
+    <h2>Sources</h2>
+    <p id="sourcesSummary" class="muted"></p>
+    <table id="sources"></table>
+
+    <h2>Now showing</h2>
+    <table id="track"></table>
+
+    <h2>Overlays</h2>
+    <table id="clients"></table>
+
+    <h2>Album art</h2>
+    <p id="artSummary" class="muted"></p>
+    <table id="art"></table>
+
+    <h2>Events</h2>
+    <table id="events"></table>
+
+    <script>
+        // Pages may be served under a tenant prefix and behind a token, so
+        // URLs are relative and carry the page's own token along
+        const TOKEN = new URLSearchParams(location.search).get('token');
+        function withToken(url) {
+            return TOKEN ? url + (url.includes('?') ? '&' : '?') + 'token=' + encodeURIComponent(TOKEN) : url;
+        }
+        const overridesLink = document.getElementById('overridesLink');
+        if (overridesLink) overridesLink.href = withToken('admin/overrides');
+
+        function ago(t) {
+            if (!t) return 'never';
+            const s = Math.round((Date.now() - new Date(t).getTime()) / 1000);
+            if (s < 60) return s + 's ago';
+            if (s < 3600) return Math.floor(s / 60) + 'm ago';
+            return Math.floor(s / 3600) + 'h ' + Math.floor(s % 3600 / 60) + 'm ago';
+        }
+
+        function duration(s) {
+            const d = Math.floor(s / 86400), h = Math.floor(s % 86400 / 3600), m = Math.floor(s % 3600 / 60);
+            return (d ? d + 'd ' : '') + h + 'h ' + m + 'm';
+        }
+
+        function rows(id, list) {
+            const table = document.getElementById(id);
+            table.innerHTML = '';
+            for (const cells of list) {
+                const tr = document.createElement('tr');
+                cells.forEach((c, i) => {
+                    const td = document.createElement(i === 0 && cells.header ? 'th' : 'td');
+                    if (c instanceof Node) {
+                        td.appendChild(c);
+                    } else if (c && typeof c === 'object') {
+                        td.textContent = c.text;
+                        td.className = c.cls || '';
+                    } else {
+                        td.textContent = c;
+                    }
+                    tr.appendChild(td);
+                });
+                table.appendChild(tr);
+            }
+        }
+
+        function pairs(id, list) {
+            rows(id, list.map(p => Object.assign(p, { header: true })));
+        }
+
+        function pin(id) {
+            const opts = id
+                ? { method: 'POST', headers: { 'Content-Type': 'application/json' }, body: JSON.stringify({ source: id }) }
+                : { method: 'DELETE' };
+            fetch(withToken('api/v1/sources/pin'), opts).then(load);
+        }
+
+        function pinButton(src) {
+            const b = document.createElement('button');
+            b.textContent = src.pinned ? 'Unpin' : 'Pin';
+            b.onclick = () => pin(src.pinned ? '' : src.id);
+            return b;
+        }
+
+        function load() {
+            fetch(withToken('admin/status')).then(r => r.json()).then(s => {
+                const src = s.source;
+                const quiet = !src.last_post || Date.now() - new Date(src.last_post).getTime() > 10000;
+                pairs('source', [
+                    ['Last post', { text: ago(src.last_post), cls: quiet ? 'bad' : 'ok' }],
+                    ['Origin', src.origin || '-'],
+                    ['User agent', src.user_agent || '-'],
+                    ['Posts per minute', String(src.posts_per_minute)],
+                    ['Posts total', src.posts_total + ' (' + src.ignored_posts + ' with nothing playing)'],
+                    ['Invalid posts', { text: src.invalid_posts + (src.last_invalid ? ', last ' + ago(src.last_invalid_at) + ': ' + src.last_invalid : ''), cls: src.invalid_posts ? 'bad' : '' }],
+                    ['Uptime', duration(s.uptime_seconds)],
+                ]);
+
+                const srcs = s.sources;
+                document.getElementById('sourcesSummary').textContent = 'Rule: ' + srcs.rule +
+                    (srcs.rule === 'priority' && srcs.priority.length ? ' (' + srcs.priority.join(', ') + ')' : '') +
+                    (srcs.pinned ? ', pinned to ' + srcs.pinned : '');
+                rows('sources', srcs.sources.length
+                    ? srcs.sources.map(x => [
+                        { text: x.id + (x.active ? ' (showing)' : ''), cls: x.active ? 'ok' : '' },
+                        x.playing ? 'playing' : 'paused',
+                        x.song_name ? x.artist + ' - ' + x.song_name : '-',
+                        ago(x.last_seen),
+                        pinButton(x),
+                    ])
+                    : [[{ text: 'No source has posted yet', cls: 'muted' }]]);
+
+                const t = s.track;
+                pairs('track', [
+                    ['State', t.state + (t.blocked ? ' (blocked)' : '') + (s.privacy.enabled ? ' (privacy mode)' : '')],
+                    ['Source', t.source || '-'],
+                    ['Title', t.song_name || '-'],
+                    ['Artist', t.artist || '-'],
+                    ['Art', t.album_art_url || '-'],
+                ]);
+
+                rows('clients', s.clients.length
+                    ? s.clients.map(c => [c.address, c.user_agent, ago(c.last_seen)])
+                    : [[{ text: 'No overlay is polling right now', cls: 'bad' }]]);
+
+                document.getElementById('artSummary').textContent =
+                    s.art.attempts + ' attempts, ' + s.art.failures + ' failed';
+                rows('art', s.art.recent.map(a => [
+                    ago(a.time),
+                    { text: a.ok ? 'ok' : 'failed', cls: a.ok ? 'ok' : 'bad' },
+                    { text: a.source, cls: 'src' },
+                    a.error || '',
+                ]));
+
+                rows('events', s.events.map(e => [ago(e.time), e.kind, e.message]));
+            }).catch(() => {});
+        }
+        load();
+        setInterval(load, 2000);
+    </script>
+</body>
+</html>
+`
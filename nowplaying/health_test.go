@@ -0,0 +1,48 @@
+package nowplaying
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAdminPageRedirect(t *testing.T) {
+	tenants := NewTenants()
+	if err := tenants.Add("alice", NewHandler(NewStore())); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		h    http.Handler
+		path string
+		want string
+	}{
+		{NewHandler(NewStore()), "/admin/", "/admin"},
+		{NewHandler(NewStore()), "/admin/?token=x", "/admin?token=x"},
+		{tenants, "/s/alice/admin/", "/s/alice/admin"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		tt.h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != http.StatusMovedPermanently {
+			t.Errorf("%s: status %d, want a redirect", tt.path, w.Code)
+			continue
+		}
+		base, _ := url.Parse("http://host" + tt.path)
+		loc, err := base.Parse(w.Header().Get("Location"))
+		if err != nil || loc.RequestURI() != tt.want {
+			t.Errorf("%s: redirects to %v, want %s", tt.path, loc, tt.want)
+		}
+	}
+}
+
+func TestNoteClientPrunes(t *testing.T) {
+	h := newHealthMonitor()
+	h.clients["old\x00agent"] = overlayClient{Address: "old", LastSeen: time.Now().Add(-2 * clientTimeout)}
+	r := httptest.NewRequest(http.MethodGet, "/now-playing", nil)
+	h.noteClient(r)
+	if _, ok := h.clients["old\x00agent"]; ok || len(h.clients) != 1 {
+		t.Errorf("clients after a poll = %v, want only the new one", h.clients)
+	}
+}
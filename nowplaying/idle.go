@@ -0,0 +1,67 @@
+package nowplaying
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	statePlaying = "playing"
+	stateIdle    = "idle"
+)
+
+// IdleMode is the layout the overlay shows when nothing is playing
+type IdleMode string
+
+const (
+	IdleMessage IdleMode = "message"
+	IdleHidden  IdleMode = "hidden"
+	IdleBRB     IdleMode = "brb"
+	// IdleLast keeps showing the last track, marked as last played
+	IdleLast IdleMode = "last"
+)
+
+const defaultIdleMessage = "Waiting for track..."
+
+func ParseIdleMode(s string) (IdleMode, error) {
+	switch m := IdleMode(s); m {
+	case IdleMessage, IdleHidden, IdleBRB, IdleLast:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown idle mode %q (use message, hidden, brb or last)", s)
+}
+
+type IdleScreen struct {
+	Mode    IdleMode `json:"mode"`
+	Message string   `json:"message,omitempty"`
+}
+
+// isStale reports whether the source has gone quiet, for example because
+// the browser tab was closed
+func (h *Handler) isStale(lastUpdate, now time.Time) bool {
+	if lastUpdate.IsZero() {
+		return true
+	}
+	return h.staleAfter > 0 && now.Sub(lastUpdate) > h.staleAfter
+}
+
+// idleView turns the last track into what the overlay shows while idle.
+// Only the "last" layout keeps the track, and only a track that was
+// actually shown.
+func (h *Handler) idleView(last NowPlaying) NowPlaying {
+	screen := &IdleScreen{Mode: h.idleMode}
+	if screen.Mode == IdleLast && (last.SongName == "" || last.Blocked || last.Hidden) {
+		screen.Mode = IdleMessage
+	}
+	switch screen.Mode {
+	case IdleLast:
+		last.State = stateIdle
+		last.Idle = screen
+		return last
+	case IdleBRB:
+		screen.Message = "BRB"
+	case IdleMessage:
+		screen.Message = h.idleMessage
+	}
+	return NowPlaying{State: stateIdle, Idle: screen}
+}
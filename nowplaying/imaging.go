@@ -0,0 +1,299 @@
+package nowplaying
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"strconv"
+	"sync"
+)
+
+const (
+	minArtSize = 16
+	maxArtSize = 2048
+	maxArtBlur = 50
+	// Blurred backgrounds don't need full resolution, so blur requests
+	// without an explicit size are rendered at this size
+	defaultBlurSize = 256
+	maxArtVariants  = 16
+	// Decoding allocates by the size a file declares, which a few bytes
+	// of header can set to gigabytes, so art is checked against this first
+	maxArtPixels = 4096 * 4096
+)
+
+// decodeArt decodes fetched or posted art once its declared dimensions
+// fit in maxArtPixels
+func decodeArt(data []byte) (image.Image, string, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > maxArtPixels {
+		return nil, "", fmt.Errorf("art is %dx%d, more than %d pixels", cfg.Width, cfg.Height, maxArtPixels)
+	}
+	return image.Decode(bytes.NewReader(data))
+}
+
+type artVariant struct {
+	data        []byte
+	contentType string
+}
+
+type artVariantKey struct {
+	size int
+	blur float64
+}
+
+// artVariantCache holds resized and blurred renderings of one art version
+type artVariantCache struct {
+	mu       sync.Mutex
+	version  int
+	variants map[artVariantKey]artVariant
+	lookups  *counterVec
+}
+
+// parseArtVariantParams reads size= and blur= from the query, clamped to
+// sane ranges. ok is false when neither is set and the original bytes
+// should be served unchanged.
+func parseArtVariantParams(size, blur string) (key artVariantKey, ok bool) {
+	if n, err := strconv.Atoi(size); err == nil && n > 0 {
+		key.size = min(max(n, minArtSize), maxArtSize)
+	}
+	if f, err := strconv.ParseFloat(blur, 64); err == nil && f > 0 && !math.IsInf(f, 0) {
+		key.blur = math.Min(f, maxArtBlur)
+	}
+	if key.blur > 0 && key.size == 0 {
+		key.size = defaultBlurSize
+	}
+	return key, key.size > 0 || key.blur > 0
+}
+
+// get returns the rendered variant for the given art version, rendering
+// and caching it on first use. The cache is dropped whenever the art
+// version changes.
+func (c *artVariantCache) get(version int, src []byte, key artVariantKey) (artVariant, error) {
+	c.mu.Lock()
+	if c.variants == nil || c.version != version {
+		c.version = version
+		c.variants = map[artVariantKey]artVariant{}
+	}
+	if v, ok := c.variants[key]; ok {
+		c.mu.Unlock()
+		c.lookups.Inc("hit")
+		return v, nil
+	}
+	c.mu.Unlock()
+	c.lookups.Inc("miss")
+
+	v, err := renderArtVariant(src, key)
+	if err != nil {
+		return artVariant{}, err
+	}
+
+	c.mu.Lock()
+	if c.version == version && len(c.variants) < maxArtVariants {
+		c.variants[key] = v
+	}
+	c.mu.Unlock()
+	return v, nil
+}
+
+func renderArtVariant(src []byte, key artVariantKey) (artVariant, error) {
+	img, format, err := decodeArt(src)
+	if err != nil {
+		return artVariant{}, err
+	}
+	out := toRGBA(img)
+	if key.size > 0 {
+		out = resizeToFit(out, key.size)
+	}
+	if key.blur > 0 {
+		out = gaussianBlur(out, key.blur)
+	}
+
+	var buf bytes.Buffer
+	if format == "png" {
+		if err := png.Encode(&buf, out); err != nil {
+			return artVariant{}, err
+		}
+		return artVariant{data: buf.Bytes(), contentType: "image/png"}, nil
+	}
+	if err := jpeg.Encode(&buf, out, &jpeg.Options{Quality: 85}); err != nil {
+		return artVariant{}, err
+	}
+	return artVariant{data: buf.Bytes(), contentType: "image/jpeg"}, nil
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) && rgba.Stride == 4*rgba.Bounds().Dx() {
+		return rgba
+	}
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(out, out.Bounds(), img, b.Min, draw.Src)
+	return out
+}
+
+// resizeToFit scales img so its longer side is at most size, keeping the
+// aspect ratio. Images that already fit are returned as-is.
+func resizeToFit(img *image.RGBA, size int) *image.RGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	nw, nh := size, size
+	if w > h {
+		nh = max(1, h*size/w)
+	} else if h > w {
+		nw = max(1, w*size/h)
+	}
+	return resizeArea(img, nw, nh)
+}
+
+// resizeArea downscales by averaging every source pixel that falls into
+// each destination pixel
+func resizeArea(src *image.RGBA, nw, nh int) *image.RGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, nw, nh))
+	for y := 0; y < nh; y++ {
+		y0, y1 := y*h/nh, (y+1)*h/nh
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < nw; x++ {
+			x0, x1 := x*w/nw, (x+1)*w/nw
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				off := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(src.Pix[off])
+					g += int(src.Pix[off+1])
+					b += int(src.Pix[off+2])
+					a += int(src.Pix[off+3])
+					off += 4
+					n++
+				}
+			}
+			off := dst.PixOffset(x, y)
+			dst.Pix[off] = uint8(r / n)
+			dst.Pix[off+1] = uint8(g / n)
+			dst.Pix[off+2] = uint8(b / n)
+			dst.Pix[off+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// resizeTo scales to exactly nw x nh, averaging when shrinking and
+// interpolating when growing
+func resizeTo(img *image.RGBA, nw, nh int) *image.RGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	switch {
+	case w == nw && h == nh:
+		return img
+	case nw <= w && nh <= h:
+		return resizeArea(img, nw, nh)
+	}
+	return resizeBilinear(img, nw, nh)
+}
+
+// cropSquare cuts the largest centered square out of img
+func cropSquare(img *image.RGBA) *image.RGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w == h {
+		return img
+	}
+	side := min(w, h)
+	r := image.Rect((w-side)/2, (h-side)/2, (w-side)/2+side, (h-side)/2+side)
+	return toRGBA(img.SubImage(r))
+}
+
+// resizeBilinear scales to exactly nw x nh with bilinear filtering. It is
+// meant for upscaling, where resizeArea would produce visible blocks.
+func resizeBilinear(src *image.RGBA, nw, nh int) *image.RGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, nw, nh))
+	if w == 0 || h == 0 {
+		return dst
+	}
+	for y := 0; y < nh; y++ {
+		fy := math.Max(0, (float64(y)+0.5)*float64(h)/float64(nh)-0.5)
+		y0 := min(int(fy), h-1)
+		y1 := min(y0+1, h-1)
+		ty := fy - float64(y0)
+		for x := 0; x < nw; x++ {
+			fx := math.Max(0, (float64(x)+0.5)*float64(w)/float64(nw)-0.5)
+			x0 := min(int(fx), w-1)
+			x1 := min(x0+1, w-1)
+			tx := fx - float64(x0)
+			o00, o10 := src.PixOffset(x0, y0), src.PixOffset(x1, y0)
+			o01, o11 := src.PixOffset(x0, y1), src.PixOffset(x1, y1)
+			off := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				top := float64(src.Pix[o00+c])*(1-tx) + float64(src.Pix[o10+c])*tx
+				bottom := float64(src.Pix[o01+c])*(1-tx) + float64(src.Pix[o11+c])*tx
+				dst.Pix[off+c] = uint8(math.Round(top*(1-ty) + bottom*ty))
+			}
+		}
+	}
+	return dst
+}
+
+// gaussianBlur applies a separable Gaussian blur with the given sigma in
+// pixels. Edges are clamped so the borders don't darken.
+func gaussianBlur(src *image.RGBA, sigma float64) *image.RGBA {
+	radius := int(math.Ceil(sigma * 3))
+	if radius < 1 {
+		return src
+	}
+	kernel := make([]float64, 2*radius+1)
+	var sum float64
+	for i := -radius; i <= radius; i++ {
+		v := math.Exp(-float64(i*i) / (2 * sigma * sigma))
+		kernel[i+radius] = v
+		sum += v
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	tmp := image.NewRGBA(image.Rect(0, 0, w, h))
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	blurPass(src, tmp, kernel, radius, w, h, 4, src.Stride)
+	blurPass(tmp, dst, kernel, radius, h, w, src.Stride, 4)
+	return dst
+}
+
+// blurPass convolves each of the n lines of length l. step moves along a
+// line and lineStep moves to the next one, so the same code handles both
+// the horizontal and vertical pass.
+func blurPass(src, dst *image.RGBA, kernel []float64, radius, l, n, step, lineStep int) {
+	for line := 0; line < n; line++ {
+		base := line * lineStep
+		for i := 0; i < l; i++ {
+			var r, g, b, a float64
+			for k := -radius; k <= radius; k++ {
+				j := min(max(i+k, 0), l-1)
+				off := base + j*step
+				kv := kernel[k+radius]
+				r += float64(src.Pix[off]) * kv
+				g += float64(src.Pix[off+1]) * kv
+				b += float64(src.Pix[off+2]) * kv
+				a += float64(src.Pix[off+3]) * kv
+			}
+			off := base + i*step
+			dst.Pix[off] = uint8(math.Round(r))
+			dst.Pix[off+1] = uint8(math.Round(g))
+			dst.Pix[off+2] = uint8(math.Round(b))
+			dst.Pix[off+3] = uint8(math.Round(a))
+		}
+	}
+}
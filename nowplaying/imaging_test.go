@@ -0,0 +1,67 @@
+package nowplaying
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// hugePNG is a PNG header that declares a w×h image without its pixels
+func hugePNG(w, h uint32) []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], w)
+	binary.BigEndian.PutUint32(ihdr[4:], h)
+	ihdr[8], ihdr[9] = 8, 6 // 8-bit RGBA
+	var b bytes.Buffer
+	b.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&b, binary.BigEndian, uint32(len(ihdr)))
+	chunk := append([]byte("IHDR"), ihdr...)
+	b.Write(chunk)
+	binary.Write(&b, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return b.Bytes()
+}
+
+func smallPNG(t *testing.T) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestDecodeArtPixelBudget(t *testing.T) {
+	huge := hugePNG(50000, 50000)
+	if _, _, err := image.DecodeConfig(bytes.NewReader(huge)); err != nil {
+		t.Fatalf("test header doesn't parse: %v", err)
+	}
+	if _, _, err := decodeArt(huge); err == nil {
+		t.Error("decodeArt accepted a 50000x50000 image")
+	}
+	if _, _, err := decodeArt(smallPNG(t)); err != nil {
+		t.Errorf("decodeArt rejected a small image: %v", err)
+	}
+	if _, err := extractPaletteFromBytes(huge); err == nil {
+		t.Error("palette extracted from a 50000x50000 image")
+	}
+}
+
+func TestRenderArtVariantPixelBudget(t *testing.T) {
+	if _, err := renderArtVariant(hugePNG(50000, 50000), artVariantKey{size: 64}); err == nil {
+		t.Error("rendered a variant of a 50000x50000 image")
+	}
+	if _, err := renderArtVariant(smallPNG(t), artVariantKey{size: 64}); err != nil {
+		t.Errorf("small image: %v", err)
+	}
+}
+
+func TestRenderCardPixelBudget(t *testing.T) {
+	// Oversized art is left off the card rather than decoded
+	img, err := renderCard(cardKey{width: 400, height: 120, theme: "dark"}, cardState{song: "Song", artist: "Artist"}, hugePNG(50000, 50000), nil)
+	if err != nil || img == nil {
+		t.Fatalf("renderCard: %v", err)
+	}
+}
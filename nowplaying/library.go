@@ -0,0 +1,285 @@
+package nowplaying
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StuxMirai/piff-music/tags"
+)
+
+var libraryExtensions = map[string]bool{
+	".mp3":  true,
+	".flac": true,
+	".m4a":  true,
+	".mp4":  true,
+	".m4b":  true,
+}
+
+type LibraryEntry struct {
+	Path   string `json:"path"`
+	Title  string `json:"title"`
+	Artist string `json:"artist"`
+	Album  string `json:"album,omitempty"`
+	Year   string `json:"year,omitempty"`
+	Genre  string `json:"genre,omitempty"`
+	HasArt bool   `json:"has_art"`
+
+	modTime time.Time
+	size    int64
+}
+
+// MusicLibrary indexes the tags of every supported file under root.
+// Art is not kept in memory; it is read from the file when a track
+// actually plays.
+type MusicLibrary struct {
+	root string
+
+	mu      sync.RWMutex
+	byPath  map[string]*LibraryEntry
+	byKey   map[string]*LibraryEntry
+	byTitle map[string][]*LibraryEntry
+}
+
+func NewMusicLibrary(root string) *MusicLibrary {
+	return &MusicLibrary{
+		root:    root,
+		byPath:  map[string]*LibraryEntry{},
+		byKey:   map[string]*LibraryEntry{},
+		byTitle: map[string][]*LibraryEntry{},
+	}
+}
+
+// Scan walks the library folder and rebuilds the index. Files whose size
+// and modification time didn't change since the last scan are not reread.
+func (l *MusicLibrary) Scan() error {
+	l.mu.RLock()
+	previous := l.byPath
+	l.mu.RUnlock()
+
+	byPath := map[string]*LibraryEntry{}
+	err := filepath.WalkDir(l.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable folders shouldn't stop the whole scan
+			if d != nil && d.IsDir() && path != l.root {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || !libraryExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if old, ok := previous[path]; ok && old.size == info.Size() && old.modTime.Equal(info.ModTime()) {
+			byPath[path] = old
+			return nil
+		}
+		md, err := tags.ReadFile(path, tags.Options{})
+		if err != nil || md.Title == "" || md.Artist == "" {
+			return nil
+		}
+		byPath[path] = &LibraryEntry{
+			Path:    path,
+			Title:   md.Title,
+			Artist:  md.Artist,
+			Album:   md.Album,
+			Year:    md.Year,
+			Genre:   md.Genre,
+			HasArt:  md.HasPicture,
+			modTime: info.ModTime(),
+			size:    info.Size(),
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	byKey := map[string]*LibraryEntry{}
+	byTitle := map[string][]*LibraryEntry{}
+	for _, e := range byPath {
+		byKey[trackKey(e.Artist, e.Title)] = e
+		t := strings.ToLower(strings.TrimSpace(e.Title))
+		byTitle[t] = append(byTitle[t], e)
+	}
+
+	l.mu.Lock()
+	l.byPath, l.byKey, l.byTitle = byPath, byKey, byTitle
+	l.mu.Unlock()
+	return nil
+}
+
+// artistSep splits an artist credit into names: commas, bullets and
+// brackets, spaced "&", "/", "x" and "vs", and featuring markers
+var artistSep = regexp.MustCompile(`(?i)\s*(?:[(\[]\s*(?:(?:feat\.?|ft\.?|featuring|with)\s)?|[,;•·)\]]|\s(?:[&/x]|vs\.?|feat\.?|ft\.?|featuring)\s)\s*`)
+
+// artistNames splits a credit into lowercased names
+func artistNames(artist string) []string {
+	var names []string
+	for _, n := range artistSep.Split(strings.ToLower(artist), -1) {
+		if n = strings.TrimSpace(n); n != "" {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// Lookup finds the entry for a track. Besides an exact artist/title
+// match it accepts entries whose artist is one or more whole names of
+// the given artist, since sources often report "Artist, Other Artist",
+// "Artist feat. Guest" or "Artist • Album". "Art" doesn't match "Arty".
+func (l *MusicLibrary) Lookup(artist, title string) (*LibraryEntry, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if e, ok := l.byKey[trackKey(artist, title)]; ok {
+		return e, true
+	}
+	names := artistNames(artist)
+	for _, e := range l.byTitle[strings.ToLower(strings.TrimSpace(title))] {
+		if containsRun(names, artistNames(e.Artist)) {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// containsRun reports whether run appears in names as consecutive names
+func containsRun(names, run []string) bool {
+	if len(run) == 0 {
+		return false
+	}
+outer:
+	for i := 0; i+len(run) <= len(names); i++ {
+		for j, n := range run {
+			if names[i+j] != n {
+				continue outer
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Search returns entries whose title, artist or album contain every word
+// of the query
+func (l *MusicLibrary) Search(q string, limit int) []LibraryEntry {
+	words := strings.Fields(strings.ToLower(q))
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var out []LibraryEntry
+	for _, e := range l.byPath {
+		hay := strings.ToLower(e.Title + " " + e.Artist + " " + e.Album)
+		match := true
+		for _, w := range words {
+			if !strings.Contains(hay, w) {
+				match = false
+				break
+			}
+		}
+		if match {
+			out = append(out, *e)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Artist != out[j].Artist {
+			return out[i].Artist < out[j].Artist
+		}
+		return out[i].Title < out[j].Title
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+func (l *MusicLibrary) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.byPath)
+}
+
+// Watch scans once and then every interval; 0 disables rescans. It only
+// returns when rescans are disabled.
+func (l *MusicLibrary) Watch(interval time.Duration) {
+	for {
+		start := time.Now()
+		if err := l.Scan(); err != nil {
+			slog.Error("library scan failed", "root", l.root, "err", err)
+		} else {
+			slog.Info("library indexed", "root", l.root, "tracks", l.Len(), "took", time.Since(start).Round(time.Millisecond))
+		}
+		if interval <= 0 {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
+
+// enrichFromLibrary fills album, year and genre from a matching library
+// entry and returns the entry
+func (h *Handler) enrichFromLibrary(t *NowPlaying) (*LibraryEntry, bool) {
+	if h.library == nil {
+		return nil, false
+	}
+	e, ok := h.library.Lookup(t.Artist, t.SongName)
+	if !ok {
+		return nil, false
+	}
+	if t.Album == "" {
+		t.Album = e.Album
+	}
+	if t.Year == "" {
+		t.Year = e.Year
+	}
+	if t.Genre == "" {
+		t.Genre = e.Genre
+	}
+	return e, true
+}
+
+// libraryArtLoader reads the embedded art of a library entry, without
+// any network fetch
+func libraryArtLoader(e *LibraryEntry) *artLoader {
+	return &artLoader{
+		source: fileURL(e.Path),
+		load: func() ([]byte, string, error) {
+			md, err := tags.ReadFile(e.Path, tags.Options{Pictures: true})
+			if err != nil {
+				return nil, "", err
+			}
+			if md.Picture == nil {
+				return nil, "", errors.New("no embedded art")
+			}
+			return md.Picture.Data, md.Picture.MIMEType, nil
+		},
+	}
+}
+
+func (h *Handler) librarySearchHandler(w http.ResponseWriter, r *http.Request) {
+	if h.library == nil {
+		http.Error(w, "No music library configured", http.StatusNotFound)
+		return
+	}
+	limit := 50
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 500 {
+		limit = n
+	}
+	results := h.library.Search(r.URL.Query().Get("q"), limit)
+	if results == nil {
+		results = []LibraryEntry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
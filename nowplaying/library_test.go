@@ -0,0 +1,50 @@
+package nowplaying
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLibraryLookup(t *testing.T) {
+	l := NewMusicLibrary("")
+	for _, e := range []*LibraryEntry{
+		{Path: "1", Title: "Song", Artist: "Art"},
+		{Path: "2", Title: "Duet", Artist: "Simon & Garfunkel"},
+		{Path: "3", Title: "Both", Artist: "Alpha; Beta"},
+	} {
+		l.byPath[e.Path] = e
+		l.byKey[trackKey(e.Artist, e.Title)] = e
+		title := strings.ToLower(e.Title)
+		l.byTitle[title] = append(l.byTitle[title], e)
+	}
+
+	tests := []struct {
+		artist, title string
+		want          string
+	}{
+		{"art", "song", "1"},
+		{"Art, Other Artist", "Song", "1"},
+		{"Other Artist & Art", "Song", "1"},
+		{"Art feat. Guest", "Song", "1"},
+		{"Guest (feat. Art)", "Song", "1"},
+		{"Art • Some Album", "Song", "1"},
+		{"Art x Guest", "Song", "1"},
+		{"Arty Farty", "Song", ""},
+		{"Smart", "Song", ""},
+		{"The Art of Noise", "Song", ""},
+		{"Simon & Garfunkel, Someone", "Duet", "2"},
+		{"Simon", "Duet", ""},
+		{"Alpha, Beta", "Both", "3"},
+		{"Alpha", "Both", ""},
+	}
+	for _, tt := range tests {
+		e, ok := l.Lookup(tt.artist, tt.title)
+		got := ""
+		if ok {
+			got = e.Path
+		}
+		if got != tt.want {
+			t.Errorf("Lookup(%q, %q) = %q, want %q", tt.artist, tt.title, got, tt.want)
+		}
+	}
+}
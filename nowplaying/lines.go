@@ -0,0 +1,155 @@
+package nowplaying
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LineSource reads one track per line from stdin or a named pipe. A line
+// is either a TrackUpdate JSON object or tab-separated key=value pairs
+// with the same keys, such as
+//
+//	song_name=Song<TAB>artist=Artist<TAB>current_timestamp=1:02
+//
+// Lines are checked like posts to /api/v1/state; bad ones are logged and
+// skipped.
+type LineSource struct {
+	// Path is a file or named pipe, or "-" for stdin. A pipe is reopened
+	// whenever its writer goes away, so scripts can come and go.
+	Path string
+	// SourceID names the source for lines that don't say, "stdin" or the
+	// file name by default
+	SourceID string
+}
+
+// lineKeyAliases are extra key=value names for common player fields
+var lineKeyAliases = map[string]string{
+	"title":    "song_name",
+	"art_url":  "album_art_url",
+	"position": "current_timestamp",
+	"length":   "end_timestamp",
+}
+
+func (s *LineSource) Run(ctx context.Context, emit func(NowPlaying)) error {
+	id := s.SourceID
+	if id == "" {
+		id = "stdin"
+		if s.Path != "-" {
+			id = strings.TrimSuffix(filepath.Base(s.Path), ".fifo")
+		}
+	}
+	if s.Path == "-" {
+		return s.read(ctx, os.Stdin, id, emit)
+	}
+	for {
+		f, err := os.Open(s.Path)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		pipe := err == nil && info.Mode()&os.ModeNamedPipe != 0
+		stop := context.AfterFunc(ctx, func() { f.Close() })
+		err = s.read(ctx, f, id, emit)
+		stop()
+		f.Close()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil || !pipe {
+			return err
+		}
+	}
+}
+
+func (s *LineSource) read(ctx context.Context, r io.Reader, id string, emit func(NowPlaying)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), maxBodySize)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		t, err := parseTrackLine(line)
+		if err != nil {
+			slog.Warn("invalid track line", "path", s.Path, "line", n, "err", err)
+			continue
+		}
+		if t.Source == "" {
+			t.Source = id
+		}
+		emit(t)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return scanner.Err()
+}
+
+// parseTrackLine decodes and checks one line of either format
+func parseTrackLine(line string) (NowPlaying, error) {
+	if strings.HasPrefix(line, "{") {
+		return parseTrackJSON([]byte(line))
+	}
+	fields := map[string]string{}
+	for _, pair := range strings.Split(line, "\t") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return NowPlaying{}, fmt.Errorf("%q is not key=value", pair)
+		}
+		fields[strings.TrimSpace(key)] = value
+	}
+	return trackFromFields(fields)
+}
+
+// parseTrackJSON checks a TrackUpdate object like a post
+func parseTrackJSON(body []byte) (NowPlaying, error) {
+	var u trackUpdate
+	if err := decodeBody(body, "TrackUpdate", &u, true); err != nil {
+		return NowPlaying{}, err
+	}
+	t, errs := u.normalize()
+	if len(errs) > 0 {
+		return NowPlaying{}, invalidFields(errs)
+	}
+	t.Source = strings.TrimSpace(u.Source)
+	return t, nil
+}
+
+// trackFromFields checks text fields, keyed like TrackUpdate or by an
+// alias, with numbers where the schema wants them
+func trackFromFields(fields map[string]string) (NowPlaying, error) {
+	obj := map[string]any{}
+	for key, value := range fields {
+		if alias, ok := lineKeyAliases[key]; ok {
+			key = alias
+		}
+		var v any = value
+		switch key {
+		case "current_seconds", "end_seconds":
+			if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+				v = n
+			}
+		case "progress_pct":
+			if f, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				v = f
+			}
+		}
+		obj[key] = v
+	}
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return NowPlaying{}, err
+	}
+	return parseTrackJSON(body)
+}
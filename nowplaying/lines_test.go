@@ -0,0 +1,65 @@
+package nowplaying
+
+import (
+	"testing"
+)
+
+func TestParseTrackLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want NowPlaying
+		err  bool
+	}{
+		{
+			name: "json",
+			line: `{"song_name":"Song","artist":"Artist","current_timestamp":"1:02","end_seconds":200,"source":"mpd"}`,
+			want: NowPlaying{SongName: "Song", Artist: "Artist", CurrentSeconds: 62, EndSeconds: 200, Source: "mpd"},
+		},
+		{
+			name: "key=value",
+			line: "song_name=Song\tartist=Artist\tcurrent_timestamp=1:02",
+			want: NowPlaying{SongName: "Song", Artist: "Artist", CurrentSeconds: 62},
+		},
+		{
+			name: "aliases and numbers",
+			line: "title=Song\tartist=Artist\tposition=0:30\tend_seconds=240\tart_url=https://example.com/a.jpg",
+			want: NowPlaying{SongName: "Song", Artist: "Artist", CurrentSeconds: 30, EndSeconds: 240, AlbumArtURL: "https://example.com/a.jpg"},
+		},
+		{
+			name: "values keep = and spaces",
+			line: "title=a = b \t artist=C",
+			want: NowPlaying{SongName: "a = b ", Artist: "C"},
+		},
+		{
+			name: "empty pairs are skipped",
+			line: "title=Song\t\tartist=Artist\t",
+			want: NowPlaying{SongName: "Song", Artist: "Artist"},
+		},
+		{name: "not key=value", line: "Artist - Song", err: true},
+		{name: "unknown key", line: "title=Song\tbitrate=320", err: true},
+		{name: "bad number", line: "title=Song\tend_seconds=long", err: true},
+		{name: "bad timestamp", line: "title=Song\tposition=soon", err: true},
+		{name: "art that isn't http", line: "title=Song\tart_url=file:///C:/a.jpg", err: true},
+		{name: "broken json", line: `{"song_name":`, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTrackLine(tt.line)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("parseTrackLine(%q) = %+v, want an error", tt.line, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTrackLine(%q): %v", tt.line, err)
+			}
+			if got.SongName != tt.want.SongName || got.Artist != tt.want.Artist || got.Source != tt.want.Source ||
+				got.CurrentSeconds != tt.want.CurrentSeconds || got.EndSeconds != tt.want.EndSeconds ||
+				got.AlbumArtURL != tt.want.AlbumArtURL {
+				t.Errorf("parseTrackLine(%q) =\n%+v\nwant\n%+v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
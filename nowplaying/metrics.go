@@ -0,0 +1,217 @@
+package nowplaying
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A few hand-rolled Prometheus metrics; the text format is simple enough
+// that a client library isn't worth the dependency
+
+type counterVec struct {
+	name, help, label string
+	// limit caps the label values kept, for labels that come from clients;
+	// values past it are counted as "other"
+	limit int
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help, label string) *counterVec {
+	return &counterVec{name: name, help: help, label: label, values: map[string]float64{}}
+}
+
+func (c *counterVec) Inc(value string) {
+	c.mu.Lock()
+	if _, ok := c.values[value]; !ok && c.limit > 0 && len(c.values) >= c.limit {
+		value = "other"
+	}
+	c.values[value]++
+	c.mu.Unlock()
+}
+
+// labelEscaper escapes a label value for the text exposition format,
+// which only knows these three escapes
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelValue(v string) string {
+	return `"` + labelEscaper.Replace(strings.ToValidUTF8(v, "\uFFFD")) + `"`
+}
+
+func (c *counterVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.label == "" {
+		fmt.Fprintf(w, "%s %g\n", c.name, c.values[""])
+		return
+	}
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=%s} %g\n", c.name, c.label, labelValue(k), c.values[k])
+	}
+}
+
+type histogram struct {
+	name, help string
+	buckets    []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(name, help string, buckets []float64) *histogram {
+	return &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=%s} %d\n", h.name, labelValue(strconv.FormatFloat(b, 'g', -1, 64)), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", h.name, h.sum, h.name, h.count)
+}
+
+func writeGauge(w io.Writer, name, help string, v float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", name, help, name, name, v)
+}
+
+type metrics struct {
+	webhookRequests   *counterVec
+	webhookDecodeErrs *counterVec
+	trackChanges      *counterVec
+	artFetchFailures  *counterVec
+	artCacheLookups   *counterVec
+	artDownloadCache  *counterVec
+	artFetchDuration  *histogram
+	sinkDeliveries    *counterVec
+	sinkFailures      *counterVec
+	sourceSwitches    *counterVec
+}
+
+// maxSourceLabels is how many source IDs get their own series
+const maxSourceLabels = 32
+
+func newMetrics() *metrics {
+	m := &metrics{
+		webhookRequests:   newCounterVec("piff_webhook_requests_total", "Webhook requests by HTTP status.", "status"),
+		webhookDecodeErrs: newCounterVec("piff_webhook_decode_errors_total", "Webhook payloads that were not valid JSON.", ""),
+		trackChanges:      newCounterVec("piff_track_changes_total", "Times the current track changed.", ""),
+		artFetchFailures:  newCounterVec("piff_art_fetch_failures_total", "Failed album art loads by reason.", "reason"),
+		artCacheLookups:   newCounterVec("piff_art_cache_requests_total", "Resized album art and card cache lookups.", "result"),
+		artDownloadCache:  newCounterVec("piff_art_download_cache_requests_total", "New tracks whose art was already downloaded (hit) or had to be loaded (miss).", "result"),
+		artFetchDuration: newHistogram("piff_art_fetch_duration_seconds", "Time to download album art.",
+			[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}),
+		sinkDeliveries: newCounterVec("piff_sink_deliveries_total", "Tracks delivered to each sink.", "sink"),
+		sinkFailures:   newCounterVec("piff_sink_failures_total", "Failed deliveries to each sink.", "sink"),
+		sourceSwitches: newCounterVec("piff_source_switches_total", "Times each source became the active one.", "source"),
+	}
+	// Source IDs come from clients, often one per tab
+	m.sourceSwitches.limit = maxSourceLabels
+	return m
+}
+
+// artFailureReason buckets an art error into a label with few values
+func artFailureReason(err error) string {
+	var netErr net.Error
+	var statusErr artStatusError
+	switch {
+	case errors.As(err, &statusErr):
+		return "http_" + strconv.Itoa(int(statusErr))
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &netErr):
+		return "network"
+	case errors.Is(err, errEmptyArt):
+		return "empty"
+	case errors.Is(err, errArtTooLarge):
+		return "too_large"
+	}
+	return "other"
+}
+
+// statusRecorder remembers the status code a handler wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// countStatus wraps h to count its responses by status code
+func countStatus(c *counterVec, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r)
+		c.Inc(strconv.Itoa(rec.status))
+	}
+}
+
+func (h *Handler) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	s := h.adminStatus()
+	now := time.Now()
+	m := h.metrics
+
+	var b strings.Builder
+	m.webhookRequests.write(&b)
+	m.webhookDecodeErrs.write(&b)
+	m.trackChanges.write(&b)
+	m.artFetchDuration.write(&b)
+	m.artFetchFailures.write(&b)
+	m.artCacheLookups.write(&b)
+	m.artDownloadCache.write(&b)
+	m.sinkDeliveries.write(&b)
+	m.sinkFailures.write(&b)
+	m.sourceSwitches.write(&b)
+
+	lastPost := 0.0
+	if s.Source.LastPost != nil {
+		lastPost = float64(s.Source.LastPost.UnixNano()) / 1e9
+	}
+	writeGauge(&b, "piff_source_last_post_timestamp_seconds", "Unix time of the last webhook post, 0 if none yet.", lastPost)
+	writeGauge(&b, "piff_source_posts_per_minute", "Webhook posts during the last minute.", float64(s.Source.PostsPerMinute))
+	idle := 0.0
+	if s.Track.State == stateIdle {
+		idle = 1
+	}
+	writeGauge(&b, "piff_idle", "1 while the overlay shows the idle screen.", idle)
+	writeGauge(&b, "piff_overlay_clients", "Overlays that polled /now-playing recently.", float64(len(s.Clients)))
+	writeGauge(&b, "piff_uptime_seconds", "Seconds since the server started.", now.Sub(s.Started).Seconds())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	io.WriteString(w, b.String())
+}
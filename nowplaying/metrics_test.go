@@ -0,0 +1,63 @@
+package nowplaying
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounterVecLabels(t *testing.T) {
+	c := newCounterVec("piff_test_total", "Test.", "source")
+	for _, v := range []string{"plain", `quo"te\slash`, "new\nline", "Bjørk", "bad\xffbyte", "tab\there"} {
+		c.Inc(v)
+	}
+	var b strings.Builder
+	c.write(&b)
+	for _, want := range []string{
+		`piff_test_total{source="plain"} 1`,
+		`piff_test_total{source="quo\"te\\slash"} 1`,
+		`piff_test_total{source="new\nline"} 1`,
+		`piff_test_total{source="Bjørk"} 1`,
+		"piff_test_total{source=\"bad�byte\"} 1",
+		"piff_test_total{source=\"tab\there\"} 1",
+	} {
+		if !strings.Contains(b.String(), want+"\n") {
+			t.Errorf("missing %s in\n%s", want, b.String())
+		}
+	}
+}
+
+func TestCounterVecLimit(t *testing.T) {
+	c := newCounterVec("piff_test_total", "Test.", "source")
+	c.limit = 3
+	for _, v := range []string{"a", "b", "c", "d", "e", "a"} {
+		c.Inc(v)
+	}
+	want := map[string]float64{"a": 2, "b": 1, "c": 1, "other": 2}
+	if len(c.values) != len(want) {
+		t.Fatalf("values = %v, want %v", c.values, want)
+	}
+	for k, v := range want {
+		if c.values[k] != v {
+			t.Errorf("values = %v, want %v", c.values, want)
+			break
+		}
+	}
+}
+
+func TestArtDownloadCacheMetric(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	h := NewHandler(NewStore())
+	defer h.Wait(context.Background())
+	art := srv.URL + "/album.jpg"
+	h.Ingest(NowPlaying{SongName: "A", Artist: "Artist", AlbumArtURL: art})
+	h.Ingest(NowPlaying{SongName: "A", Artist: "Artist", AlbumArtURL: art, CurrentSeconds: 1})
+	// Same album, same art
+	h.Ingest(NowPlaying{SongName: "B", Artist: "Artist", AlbumArtURL: art})
+	if got := h.metrics.artDownloadCache.values; got["miss"] != 1 || got["hit"] != 1 {
+		t.Errorf("download cache lookups = %v, want one miss and one hit", got)
+	}
+}
@@ -0,0 +1,81 @@
+// Package nowplaying is the now-playing server behind the piff-music
+// overlay. A Store holds the current track and its album art, a Handler
+// serves the overlay and its APIs on top of a Store, Sources feed tracks
+// in and Sinks receive them.
+//
+//	store := nowplaying.NewStore()
+//	h := nowplaying.NewHandler(store, nowplaying.WithIdleScreen(nowplaying.IdleHidden, "", time.Minute))
+//	http.ListenAndServe(":17890", h)
+package nowplaying
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// NowPlaying is a track as sources post it and as /now-playing reports it.
+// The fields from Palette on are filled in by the server.
+type NowPlaying struct {
+	SongName         string   `json:"song_name"`
+	Artist           string   `json:"artist"`
+	CurrentTimestamp string   `json:"current_timestamp"`
+	EndTimestamp     string   `json:"end_timestamp"`
+	AlbumArtURL      string   `json:"album_art_url"`
+	AlbumArtVersion  int      `json:"album_art_version,omitempty"`
+	CurrentSeconds   int      `json:"current_seconds,omitempty"`
+	EndSeconds       int      `json:"end_seconds,omitempty"`
+	VideoID          string   `json:"video_id,omitempty"`
+	RawSongName      string   `json:"raw_song_name,omitempty"`
+	RawArtist        string   `json:"raw_artist,omitempty"`
+	FeaturedArtists  []string `json:"featured_artists,omitempty"`
+	Album            string   `json:"album,omitempty"`
+	Year             string   `json:"year,omitempty"`
+	Genre            string   `json:"genre,omitempty"`
+	// Source is the ID of the source that posted the track
+	Source string `json:"source,omitempty"`
+	// ProgressPct is worked out from the seconds; posted values are ignored
+	ProgressPct float64  `json:"progress_pct,omitempty"`
+	Palette     *Palette `json:"palette,omitempty"`
+	// Blocked tracks show a placeholder, or nothing when Hidden is set.
+	// Anything that records or forwards tracks should skip them.
+	Blocked bool `json:"blocked,omitempty"`
+	Hidden  bool `json:"hidden,omitempty"`
+	// State is "playing" or "idle"; Idle says what to show when idle
+	State      string      `json:"state,omitempty"`
+	Idle       *IdleScreen `json:"idle,omitempty"`
+	LastUpdate *time.Time  `json:"last_update,omitempty"`
+
+	// cover is art a Source found on disk, which posted tracks can't set
+	cover *artLoader
+}
+
+// Source feeds tracks into a Handler. Run calls emit for every track it
+// reads and returns when ctx is done or the source ends. A track with
+// neither a title nor an artist says the source stopped playing.
+type Source interface {
+	Run(ctx context.Context, emit func(NowPlaying)) error
+}
+
+// Sink receives every track that starts playing. Blocked tracks and
+// anything played in privacy mode never reach a sink.
+type Sink interface {
+	Name() string
+	Deliver(ctx context.Context, t NowPlaying) error
+}
+
+func fileURL(path string) string {
+	p := filepath.ToSlash(path)
+	if !strings.HasPrefix(p, "/") {
+		// Windows drive paths: file:///C:/Music/...
+		p = "/" + p
+	}
+	return (&url.URL{Scheme: "file", Path: p}).String()
+}
+
+// trackKey identifies a track independent of case and surrounding space
+func trackKey(artist, title string) string {
+	return strings.ToLower(strings.TrimSpace(artist)) + "\x00" + strings.ToLower(strings.TrimSpace(title))
+}
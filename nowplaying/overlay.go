@@ -0,0 +1,326 @@
+package nowplaying
+
+import (
+	"html/template"
+	"net/http"
+)
+
+func (h *Handler) indexHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := template.New("index").Parse(htmlTemplate)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Execute(w, nil)
+}
+
+const htmlTemplate = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Now Playing</title>
+    <style>
+        body, html {
+            margin: 0;
+            padding: 0;
+            font-family: system-ui, -apple-system, Segoe UI, Roboto, Helvetica, Arial, sans-serif;
+            height: 100%;
+            overflow: hidden;
+            background: transparent;
+        }
+        .container {
+            width: 100%;
+            height: 100%;
+            display: flex;
+            justify-content: center;
+            align-items: center;
+            padding: clamp(6px, 2vw, 16px);
+        }
+        .now-playing {
+            position: relative;
+            background-color: #000;
+            border-radius: clamp(12px, 2vw, 18px);
+            padding: 0;
+            width: 90%;
+            max-width: 800px;
+            overflow: hidden;
+            box-shadow: 0 20px 60px rgba(0, 0, 0, 0.6);
+            border: 1px solid rgba(255,255,255,0.08);
+        }
+        .now-playing::before {
+            content: "";
+            position: absolute;
+            inset: 0;
+            background-image: var(--album-url, none);
+            background-size: cover;
+            background-position: center;
+            background-repeat: no-repeat;
+            filter: brightness(0.82) saturate(1.15);
+            transform: scale(1.1);
+            z-index: 0;
+        }
+        /* Art the server couldn't blur comes back as it is */
+        .now-playing.css-blur::before {
+            filter: blur(6px) brightness(0.82) saturate(1.15);
+        }
+        .now-playing::after {
+            content: "";
+            position: absolute;
+            inset: 0;
+            background:
+                linear-gradient(to top, rgba(0,0,0,0.4), transparent 35%),
+                linear-gradient(to bottom, rgba(0,0,0,0.4), transparent 35%),
+                linear-gradient(to left, rgba(0,0,0,0.4), transparent 35%),
+                linear-gradient(to right, rgba(0,0,0,0.4), transparent 35%);
+            z-index: 1;
+            pointer-events: none;
+        }
+        .content {
+            position: relative;
+            z-index: 2;
+            padding: clamp(16px, 4vw, 28px) clamp(16px, 4vw, 28px) clamp(12px, 3vw, 22px);
+            display: flex;
+            flex-direction: column;
+            gap: 10px;
+            background: none;
+            backdrop-filter: none;
+        }
+        .song-name {
+            font-size: clamp(1.4rem, 5vw, 3rem);
+            font-weight: bold;
+            margin: 0;
+            color: white;
+            text-shadow: 
+                -1px -1px 0 #000,
+                1px -1px 0 #000,
+                -1px 1px 0 #000,
+                1px 1px 0 #000;
+            overflow: hidden;
+            white-space: nowrap;
+            will-change: transform;
+        }
+        .artist-name {
+            font-size: clamp(1rem, 2.6vw, 1.5rem);
+            color: white;
+            margin: 10px 0;
+            text-shadow: 
+                -1px -1px 0 #000,
+                1px -1px 0 #000,
+                -1px 1px 0 #000,
+                1px 1px 0 #000;
+            opacity: 0.95;
+            overflow: hidden;
+            white-space: nowrap;
+            will-change: transform;
+        }
+        .progress-bar {
+            width: 100%;
+            height: clamp(6px, 1.2vw, 12px);
+            background-color: rgba(255, 255, 255, 0.35);
+            border-radius: 5px;
+            overflow: hidden;
+            margin: 15px 0;
+            border: 1px solid rgba(255,255,255,0.25);
+            box-shadow: inset 0 2px 8px rgba(0,0,0,0.4);
+        }
+        .progress {
+            width: 0%;
+            height: 100%;
+            background: linear-gradient(90deg, #9b59b6, #8e44ad, #6c5ce7, #9b59b6);
+            background-size: 200% 100%;
+            transition: width 0.5s ease-in-out;
+            animation: progressFlow 10s linear infinite;
+        }
+        @keyframes progressFlow {
+            0% { background-position: 0% 0; }
+            100% { background-position: 200% 0; }
+        }
+        .timestamp {
+            font-size: clamp(0.8rem, 2vw, 0.95rem);
+            color: white;
+            text-shadow: 
+                -1px -1px 0 #000,
+                1px -1px 0 #000,
+                -1px 1px 0 #000,
+                1px 1px 0 #000;
+            opacity: 0.9;
+            align-self: flex-end;
+        }
+        
+        .marquee {
+            animation: marquee 12s linear infinite;
+        }
+        @keyframes marquee {
+            0% { transform: translateX(0); }
+            100% { transform: translateX(-100%); }
+        }
+        .song-name, .artist-name { position: relative; z-index: 3; }
+
+        @media (prefers-reduced-motion: reduce) {
+            .marquee { animation: none; }
+            .progress { animation: none; }
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="now-playing">
+            <div class="content">
+                <h1 class="song-name" id="songName">Waiting for track...</h1>
+                <p class="artist-name" id="artistName">Unknown Artist</p>
+                <div class="progress-bar">
+                    <div class="progress" id="progressBar"></div>
+                </div>
+                <p class="timestamp" id="timestamp"></p>
+            </div>
+        </div>
+    </div>
+
+    <script>
+        function onResize() {
+            // Re-evaluate marquee when layout changes
+            applyMarqueeIfOverflow('songName');
+            applyMarqueeIfOverflow('artistName');
+        }
+        function updateNowPlaying() {
+            fetch('now-playing')
+                .then(response => response.json())
+                .then(data => {
+                    const idle = data.state === 'idle' ? (data.idle || { mode: 'message' }) : null;
+                    const hidden = data.hidden || (idle && idle.mode === 'hidden');
+                    document.querySelector('.container').style.visibility = hidden ? 'hidden' : 'visible';
+                    if (idle && idle.mode === 'last') {
+                        document.getElementById('songName').textContent = data.song_name;
+                        document.getElementById('artistName').textContent = data.artist;
+                        document.getElementById('timestamp').textContent = 'Last played';
+                        document.getElementById('progressBar').style.width = '0%';
+                        updateBackground(data.album_art_url, data.album_art_version);
+                        applyProgressGradient(data.palette);
+                        applyMarqueeIfOverflow('songName');
+                        applyMarqueeIfOverflow('artistName');
+                    } else if (idle) {
+                        document.getElementById('songName').textContent = idle.message || '';
+                        document.getElementById('artistName').textContent = '';
+                        document.getElementById('timestamp').textContent = '';
+                        document.getElementById('progressBar').style.width = '0%';
+                        updateBackground(null);
+                        removeMarquee('songName');
+                        removeMarquee('artistName');
+                    } else if (data.song_name) {
+                        document.getElementById('songName').textContent = data.song_name;
+                        document.getElementById('artistName').textContent = data.artist;
+                        document.getElementById('timestamp').textContent = data.current_timestamp + ' / ' + data.end_timestamp;
+                        updateProgressBar(data.current_timestamp, data.end_timestamp, data.current_seconds, data.end_seconds);
+                        updateBackground(data.album_art_url, data.album_art_version);
+                        applyProgressGradient(data.palette);
+                        applyMarqueeIfOverflow('songName');
+                        applyMarqueeIfOverflow('artistName');
+                    } else {
+                        document.getElementById('songName').textContent = 'Waiting for track...';
+                        document.getElementById('artistName').textContent = 'Unknown Artist';
+                        document.getElementById('timestamp').textContent = '';
+                        document.getElementById('progressBar').style.width = '0%';
+                        updateBackground(null);
+                        removeMarquee('songName');
+                        removeMarquee('artistName');
+                    }
+                })
+                .catch(error => console.error('Error:', error));
+        }
+
+        function updateProgressBar(current, end, currentSeconds, endSeconds) {
+            const currentTime = (Number.isFinite(currentSeconds) && currentSeconds >= 0) ? currentSeconds : timeToSeconds(current);
+            const endTime = (Number.isFinite(endSeconds) && endSeconds >= 0) ? endSeconds : timeToSeconds(end);
+            const progress = endTime > 0 ? (currentTime / endTime) * 100 : 0;
+            document.getElementById('progressBar').style.width = Math.min(100, Math.max(0, progress)) + '%';
+        }
+
+        function timeToSeconds(timeString) {
+            if (!timeString || typeof timeString !== 'string' || !timeString.includes(':')) return 0;
+            const parts = timeString.split(':').map(Number);
+            if (parts.length < 2 || parts.length > 3 || parts.some(isNaN)) return 0;
+            return parts.reduce((total, n) => total * 60 + n, 0);
+        }
+
+        // blur is in pixels of the BG_ART_SIZE bitmap; 2px at 256 is about
+        // the old 6px CSS blur at full widget size
+        const BG_ART_SIZE = 256;
+        const BG_ART_BLUR = 2;
+        let bgVersion = null;
+        let bgObjectUrl = null;
+        function updateBackground(albumUrl, version) {
+            const container = document.querySelector('.now-playing');
+            if (!albumUrl) {
+                bgVersion = null;
+                container.style.setProperty('--album-url', 'none');
+                container.classList.remove('css-blur');
+                return;
+            }
+            const v = (Number.isFinite(version) ? version : 0);
+            if (v === bgVersion) return;
+            bgVersion = v;
+            // Pre-blurred on the server; a small bitmap stretched by CSS is
+            // much cheaper for OBS than a live CSS blur. Art the server can't
+            // decode comes back unchanged and is blurred here instead.
+            const localUrl = 'album-art?v=' + v + '&size=' + BG_ART_SIZE + '&blur=' + BG_ART_BLUR;
+            fetch(localUrl)
+                .then(r => {
+                    if (!r.ok) throw new Error('album art: HTTP ' + r.status);
+                    const original = r.headers.get('X-Art-Variant') === 'original';
+                    return r.blob().then(blob => ({ blob, original }));
+                })
+                .then(({ blob, original }) => {
+                    if (v !== bgVersion) return;
+                    if (bgObjectUrl) URL.revokeObjectURL(bgObjectUrl);
+                    bgObjectUrl = URL.createObjectURL(blob);
+                    container.style.setProperty('--album-url', 'url(' + "'" + bgObjectUrl + "'" + ')');
+                    container.classList.toggle('css-blur', original);
+                })
+                .catch(error => {
+                    if (v === bgVersion) bgVersion = null;
+                    console.error('Error:', error);
+                });
+        }
+
+        function applyProgressGradient(palette) {
+            const el = document.getElementById('progressBar');
+            if (!el || !palette) return;
+            const { base, accent1, accent2 } = palette;
+            el.style.background = 'linear-gradient(90deg, ' + base + ', ' + accent1 + ', ' + accent2 + ')';
+        }
+
+        function applyMarqueeIfOverflow(elementId) {
+            const el = document.getElementById(elementId);
+            if (!el) return;
+            // Force layout before measuring
+            el.offsetWidth;
+            if (el.scrollWidth > el.clientWidth) {
+                el.classList.add('marquee');
+            } else {
+                el.classList.remove('marquee');
+            }
+        }
+
+        function removeMarquee(elementId) {
+            const el = document.getElementById(elementId);
+            if (!el) return;
+            el.classList.remove('marquee');
+        }
+        updateNowPlaying();
+        setInterval(updateNowPlaying, 1000);
+        window.addEventListener('resize', onResize);
+    </script>
+</body>
+</html>
+`
+
+const nowPlayingTemplate = `
+{{if .SongName}}
+Now Playing: {{.SongName}} by {{.Artist}} ({{.Timestamp}})
+{{else}}
+Waiting for track information...
+{{end}}
+`
@@ -0,0 +1,435 @@
+package nowplaying
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// TrackOverride replaces parts of a track before it is shown. It matches
+// by video ID, or by artist and title compared like trackKey against
+// both the cleaned and the raw values.
+type TrackOverride struct {
+	ID string `json:"id"`
+
+	VideoID     string `json:"video_id,omitempty"`
+	MatchArtist string `json:"match_artist,omitempty"`
+	MatchTitle  string `json:"match_title,omitempty"`
+
+	SongName    string `json:"song_name,omitempty"`
+	Artist      string `json:"artist,omitempty"`
+	AlbumArtURL string `json:"album_art_url,omitempty"`
+	// LocalArt is an image file in the store's art folder, as a path
+	// relative to it or an absolute one inside it
+	LocalArt string `json:"local_art,omitempty"`
+}
+
+type OverrideStore struct {
+	path string
+	// artDir is the only folder local art may come from, since
+	// /album-art serves whatever an override loads
+	artDir string
+
+	mu    sync.RWMutex
+	items []TrackOverride
+}
+
+// LoadOverrideStore reads the overrides file; a missing file is an empty
+// store that gets created on the first change. Local art must be an image
+// inside artDir; with no artDir, overrides can't use local art.
+func LoadOverrideStore(path, artDir string) (*OverrideStore, error) {
+	s := &OverrideStore{path: path, artDir: artDir}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.items); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *OverrideStore) match(t *NowPlaying) (TrackOverride, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key := trackKey(t.Artist, t.SongName)
+	rawKey := trackKey(t.RawArtist, t.RawSongName)
+	for _, o := range s.items {
+		if o.VideoID != "" {
+			if o.VideoID == t.VideoID {
+				return o, true
+			}
+			continue
+		}
+		k := trackKey(o.MatchArtist, o.MatchTitle)
+		if k == key || k == rawKey {
+			return o, true
+		}
+	}
+	return TrackOverride{}, false
+}
+
+// Apply rewrites t with the first matching override and returns a loader
+// for its local art, if it has any
+func (s *OverrideStore) Apply(t *NowPlaying) *artLoader {
+	o, ok := s.match(t)
+	if !ok {
+		return nil
+	}
+	if o.SongName != "" {
+		t.SongName = o.SongName
+	}
+	if o.Artist != "" {
+		t.Artist = o.Artist
+	}
+	if o.AlbumArtURL != "" {
+		t.AlbumArtURL = o.AlbumArtURL
+	}
+	if o.LocalArt != "" {
+		path, err := s.localArtPath(o.LocalArt)
+		if err != nil {
+			slog.Warn("ignoring override art", "id", o.ID, "err", err)
+			return nil
+		}
+		return fileArtLoader(path)
+	}
+	return nil
+}
+
+// localArtPath resolves p to an image file inside the art folder. Links
+// are followed first so they can't point out of it.
+func (s *OverrideStore) localArtPath(p string) (string, error) {
+	if s.artDir == "" {
+		return "", errors.New("local art is off because no art folder is set")
+	}
+	if ctype := mime.TypeByExtension(strings.ToLower(filepath.Ext(p))); !strings.HasPrefix(ctype, "image/") {
+		return "", fmt.Errorf("%s is not an image file", p)
+	}
+	dir, err := filepath.Abs(s.artDir)
+	if err == nil {
+		dir, err = filepath.EvalSymlinks(dir)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dir, p)
+	}
+	path, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not inside the art folder %s", p, s.artDir)
+	}
+	return path, nil
+}
+
+func (s *OverrideStore) List() []TrackOverride {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]TrackOverride{}, s.items...)
+}
+
+// Put adds o, or replaces the override with the same ID
+func (s *OverrideStore) Put(o TrackOverride) (TrackOverride, error) {
+	o.VideoID = strings.TrimSpace(o.VideoID)
+	o.MatchArtist = strings.TrimSpace(o.MatchArtist)
+	o.MatchTitle = strings.TrimSpace(o.MatchTitle)
+	if o.VideoID == "" && (o.MatchArtist == "" || o.MatchTitle == "") {
+		return o, errors.New("an override needs a video ID or both an artist and a title to match")
+	}
+	if o.SongName == "" && o.Artist == "" && o.AlbumArtURL == "" && o.LocalArt == "" {
+		return o, errors.New("an override needs something to replace")
+	}
+	if o.LocalArt != "" {
+		if _, err := s.localArtPath(o.LocalArt); err != nil {
+			return o, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := append([]TrackOverride{}, s.items...)
+	replaced := false
+	if o.ID != "" {
+		for i := range items {
+			if items[i].ID == o.ID {
+				items[i] = o
+				replaced = true
+			}
+		}
+	}
+	if !replaced {
+		o.ID = newOverrideID()
+		items = append(items, o)
+	}
+	if err := s.saveLocked(items); err != nil {
+		return o, err
+	}
+	return o, nil
+}
+
+func (s *OverrideStore) Delete(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var items []TrackOverride
+	for _, o := range s.items {
+		if o.ID != id {
+			items = append(items, o)
+		}
+	}
+	if len(items) == len(s.items) {
+		return false, nil
+	}
+	return true, s.saveLocked(items)
+}
+
+// saveLocked writes items to disk and only then makes them current, so a
+// failed write doesn't leave memory and file disagreeing
+func (s *OverrideStore) saveLocked(items []TrackOverride) error {
+	if items == nil {
+		items = []TrackOverride{}
+	}
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.items = items
+	return nil
+}
+
+func newOverrideID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// fileArtLoader reads art from an image file on disk. Files that don't
+// look like an image aren't loaded.
+func fileArtLoader(path string) *artLoader {
+	return &artLoader{
+		source: fileURL(path),
+		load: func() ([]byte, string, error) {
+			if info, err := os.Stat(path); err == nil && info.Size() > maxArtBytes {
+				return nil, "", errArtTooLarge
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, "", err
+			}
+			ctype := http.DetectContentType(data)
+			if !strings.HasPrefix(ctype, "image/") {
+				return nil, "", fmt.Errorf("%s is not an image", path)
+			}
+			return data, ctype, nil
+		},
+	}
+}
+
+// republish ingests the active source's last track again so override
+// edits show up on the overlay without waiting for the next post. It
+// goes through arbitration like a post, so a source that isn't shown
+// stays off the overlay.
+func (h *Handler) republish() {
+	h.postMu.Lock()
+	defer h.postMu.Unlock()
+	if src := h.sources.lookup(h.sources.active); src != nil && src.track.SongName != "" {
+		h.ingestFrom(src, src.track, src.lastSeen, true)
+	}
+}
+
+// overridesPage reports whether /admin/overrides is served. The page can
+// point local art at files, so it needs a token even on the local machine.
+func (h *Handler) overridesPage() bool {
+	return h.overrides != nil && h.token != ""
+}
+
+func (h *Handler) overridesPageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(overridesPageHTML))
+}
+
+func (h *Handler) overridesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	overrides := h.overrides
+	switch r.Method {
+	case http.MethodGet:
+		incoming := h.store.LastIncoming()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Overrides []TrackOverride `json:"overrides"`
+			Incoming  NowPlaying      `json:"incoming"`
+		}{overrides.List(), incoming})
+	case http.MethodPost:
+		// Requiring JSON keeps other sites from posting forms here
+		if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/json" {
+			http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+		var o TrackOverride
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&o); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		saved, err := overrides.Put(o)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		h.republish()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(saved)
+	case http.MethodDelete:
+		ok, err := overrides.Delete(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		h.republish()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+const overridesPageHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>Track Overrides</title>
+    <style>
+        body { font-family: system-ui, -apple-system, Segoe UI, Roboto, Helvetica, Arial, sans-serif; background: #15151a; color: #eee; margin: 24px; }
+        h1 { font-size: 1.4rem; }
+        table { border-collapse: collapse; width: 100%; margin-bottom: 24px; }
+        th, td { text-align: left; padding: 6px 8px; border-bottom: 1px solid #333; font-size: 0.9rem; }
+        fieldset { border: 1px solid #333; border-radius: 8px; margin-bottom: 12px; }
+        label { display: inline-block; width: 140px; }
+        input { width: 360px; background: #222; color: #eee; border: 1px solid #444; border-radius: 4px; padding: 4px 6px; margin: 3px 0; }
+        button { background: #6c5ce7; color: white; border: 0; border-radius: 4px; padding: 6px 12px; cursor: pointer; margin-right: 6px; }
+        button.secondary { background: #444; }
+        #error { color: #ff7675; }
+        .muted { color: #999; }
+    </style>
+</head>
+<body>
+    <h1>Track Overrides</h1>
+    <p class="muted">Incoming: <span id="incoming">nothing yet</span></p>
+    <table>
+        <thead><tr><th>Match</th><th>Title</th><th>Artist</th><th>Art</th><th></th></tr></thead>
+        <tbody id="rows"></tbody>
+    </table>
+    <form id="form">
+        <input type="hidden" name="id">
+        <fieldset>
+            <legend>Match</legend>
+            <label>Video ID</label><input name="video_id"><br>
+            <label>Artist</label><input name="match_artist"><br>
+            <label>Title</label><input name="match_title">
+        </fieldset>
+        <fieldset>
+            <legend>Replace with</legend>
+            <label>Title</label><input name="song_name"><br>
+            <label>Artist</label><input name="artist"><br>
+            <label>Art URL</label><input name="album_art_url"><br>
+            <label>Local art file</label><input name="local_art" placeholder="song.jpg">
+        </fieldset>
+        <button type="submit">Save</button>
+        <button type="button" class="secondary" id="useCurrent">Use incoming track</button>
+        <button type="button" class="secondary" id="clear">Clear</button>
+        <p id="error"></p>
+    </form>
+    <script>
+        // Relative URLs carrying the page's token, as on the status page
+        const TOKEN = new URLSearchParams(location.search).get('token');
+        function withToken(url) {
+            return TOKEN ? url + (url.includes('?') ? '&' : '?') + 'token=' + encodeURIComponent(TOKEN) : url;
+        }
+        const form = document.getElementById('form');
+        const fields = ['id', 'video_id', 'match_artist', 'match_title', 'song_name', 'artist', 'album_art_url', 'local_art'];
+        let incoming = null;
+
+        function load() {
+            fetch(withToken('overrides/api')).then(r => r.json()).then(data => {
+                incoming = data.incoming;
+                document.getElementById('incoming').textContent = incoming && incoming.song_name
+                    ? incoming.song_name + ' by ' + incoming.artist + (incoming.video_id ? ' (' + incoming.video_id + ')' : '')
+                    : 'nothing yet';
+                const rows = document.getElementById('rows');
+                rows.innerHTML = '';
+                for (const o of data.overrides) {
+                    const tr = document.createElement('tr');
+                    const match = o.video_id ? 'video ' + o.video_id : o.match_artist + ' – ' + o.match_title;
+                    for (const text of [match, o.song_name || '', o.artist || '', o.local_art || o.album_art_url || '']) {
+                        const td = document.createElement('td');
+                        td.textContent = text;
+                        tr.appendChild(td);
+                    }
+                    const td = document.createElement('td');
+                    const edit = document.createElement('button');
+                    edit.textContent = 'Edit';
+                    edit.className = 'secondary';
+                    edit.onclick = () => fields.forEach(f => form.elements[f].value = o[f] || '');
+                    const del = document.createElement('button');
+                    del.textContent = 'Delete';
+                    del.className = 'secondary';
+                    del.onclick = () => fetch(withToken('overrides/api?id=' + encodeURIComponent(o.id)), { method: 'DELETE' }).then(load);
+                    td.append(edit, del);
+                    tr.appendChild(td);
+                    rows.appendChild(tr);
+                }
+            });
+        }
+
+        form.addEventListener('submit', (e) => {
+            e.preventDefault();
+            const body = {};
+            fields.forEach(f => body[f] = form.elements[f].value.trim());
+            fetch(withToken('overrides/api'), {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify(body)
+            }).then(async r => {
+                if (!r.ok) throw new Error(await r.text());
+                document.getElementById('error').textContent = '';
+                form.reset();
+                load();
+            }).catch(err => document.getElementById('error').textContent = err.message);
+        });
+
+        document.getElementById('useCurrent').onclick = () => {
+            if (!incoming) return;
+            form.elements['video_id'].value = incoming.video_id || '';
+            form.elements['match_artist'].value = incoming.artist || '';
+            form.elements['match_title'].value = incoming.song_name || '';
+        };
+        document.getElementById('clear').onclick = () => form.reset();
+
+        load();
+    </script>
+</body>
+</html>
+`
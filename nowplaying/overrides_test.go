@@ -0,0 +1,60 @@
+package nowplaying
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testOverrides(t *testing.T) *OverrideStore {
+	t.Helper()
+	s, err := LoadOverrideStore(filepath.Join(t.TempDir(), "overrides.json"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRepublishFollowsActiveSource(t *testing.T) {
+	overrides := testOverrides(t)
+	h := NewHandler(NewStore(), WithOverrides(overrides), WithToken("secret"))
+	defer h.Wait(context.Background())
+	h.pinSource("shown")
+	h.Ingest(NowPlaying{Source: "shown", SongName: "A", Artist: "Artist"})
+	h.Ingest(NowPlaying{Source: "hidden", SongName: "B", Artist: "Artist"})
+
+	if _, err := overrides.Put(TrackOverride{MatchArtist: "Artist", MatchTitle: "B", SongName: "B (fixed)"}); err != nil {
+		t.Fatal(err)
+	}
+	h.republish()
+	if v := h.view(); v.SongName != "A" {
+		t.Fatalf("after editing another source's track: showing %q, want A", v.SongName)
+	}
+
+	if _, err := overrides.Put(TrackOverride{MatchArtist: "Artist", MatchTitle: "A", SongName: "A (fixed)"}); err != nil {
+		t.Fatal(err)
+	}
+	h.republish()
+	if v := h.view(); v.SongName != "A (fixed)" {
+		t.Errorf("after editing the shown track: showing %q, want A (fixed)", v.SongName)
+	}
+}
+
+func TestAdminOverridesLink(t *testing.T) {
+	for _, token := range []string{"", "secret"} {
+		h := NewHandler(NewStore(), WithOverrides(testOverrides(t)), WithToken(token))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin?token=secret", nil))
+		if got, want := strings.Contains(w.Body.String(), `id="overridesLink"`), token != ""; got != want {
+			t.Errorf("token %q: overrides link shown %v, want %v", token, got, want)
+		}
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/overrides?token=secret", nil))
+		if got, want := strings.Contains(w.Body.String(), "<title>Track Overrides</title>"), token != ""; got != want {
+			t.Errorf("token %q: overrides page served %v, want %v", token, got, want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package nowplaying
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+
+	// YouTube Music serves most covers as WebP
+	_ "golang.org/x/image/webp"
+)
+
+type Palette struct {
+	Base    string `json:"base"`
+	Accent1 string `json:"accent1"`
+	Accent2 string `json:"accent2"`
+	Text    string `json:"text"`
+}
+
+// Same sampling size the overlay used for its canvas
+const paletteSampleSize = 64
+
+func extractPaletteFromBytes(data []byte) (*Palette, error) {
+	img, _, err := decodeArt(data)
+	if err != nil {
+		return nil, err
+	}
+	return extractPalette(resizeArea(toRGBA(img), paletteSampleSize, paletteSampleSize)), nil
+}
+
+// extractPalette builds a hue histogram of saturated pixels and derives
+// a base color plus two hue-rotated accents from the dominant bin
+func extractPalette(img *image.RGBA) *Palette {
+	var bins, hAcc, sAcc, lAcc [12]float64
+	var avgR, avgG, avgB float64
+	count := 0
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		r, g, b := float64(img.Pix[i])/255, float64(img.Pix[i+1])/255, float64(img.Pix[i+2])/255
+		if float64(img.Pix[i+3])/255 < 0.5 {
+			continue
+		}
+		avgR += r
+		avgG += g
+		avgB += b
+		count++
+		h, s, l := rgbToHSL(r, g, b)
+		if s > 0.4 && l > 0.2 && l < 0.8 {
+			bin := int(math.Floor(h*360/30)) % 12
+			bins[bin]++
+			hAcc[bin] += h
+			sAcc[bin] += s
+			lAcc[bin] += l
+		}
+	}
+
+	var baseR, baseG, baseB float64
+	if count > 0 {
+		maxIdx, maxVal := -1, 0.0
+		for i := range bins {
+			if bins[i] > maxVal {
+				maxVal = bins[i]
+				maxIdx = i
+			}
+		}
+		if maxVal > 0 {
+			h := hAcc[maxIdx] / maxVal
+			s := math.Min(1, sAcc[maxIdx]/maxVal*1.05)
+			l := lAcc[maxIdx] / maxVal
+			baseR, baseG, baseB = hslToRGB(h, s, l)
+		} else {
+			n := float64(count)
+			baseR, baseG, baseB = avgR/n, avgG/n, avgB/n
+		}
+	} else {
+		baseR, baseG, baseB = 0.6, 0.4, 0.8
+	}
+
+	// Ensure minimum brightness via HSL lightness clamps
+	bh, bs, bl := rgbToHSL(baseR, baseG, baseB)
+	bs = clamp01(bs * 1.05)
+	bl = clamp01(math.Max(0.50, bl))
+
+	br, bg, bb := hslToRGB(bh, bs, bl)
+	a1r, a1g, a1b := hslToRGB(rotateHue(bh, 20.0/360), clamp01(bs*1.05), clamp01(math.Max(0.56, bl)))
+	a2r, a2g, a2b := hslToRGB(rotateHue(bh, -20.0/360), clamp01(bs*0.95), clamp01(math.Max(0.48, bl*0.95)))
+
+	return &Palette{
+		Base:    cssHex(br, bg, bb),
+		Accent1: cssHex(a1r, a1g, a1b),
+		Accent2: cssHex(a2r, a2g, a2b),
+		Text:    contrastText(br, bg, bb),
+	}
+}
+
+// contrastText picks black or white, whichever has the higher WCAG
+// contrast ratio against the given background
+func contrastText(r, g, b float64) string {
+	l := relativeLuminance(r, g, b)
+	onWhite := 1.05 / (l + 0.05)
+	onBlack := (l + 0.05) / 0.05
+	if onBlack >= onWhite {
+		return "#000000"
+	}
+	return "#ffffff"
+}
+
+func relativeLuminance(r, g, b float64) float64 {
+	lin := func(c float64) float64 {
+		if c <= 0.03928 {
+			return c / 12.92
+		}
+		return math.Pow((c+0.055)/1.055, 2.4)
+	}
+	return 0.2126*lin(r) + 0.7152*lin(g) + 0.0722*lin(b)
+}
+
+func rotateHue(h, delta float64) float64 {
+	x := math.Mod(h+delta, 1)
+	if x < 0 {
+		x += 1
+	}
+	return x
+}
+
+func clamp01(x float64) float64 {
+	return math.Max(0, math.Min(1, x))
+}
+
+func cssHex(r, g, b float64) string {
+	to8 := func(c float64) int { return int(math.Round(clamp01(c) * 255)) }
+	return fmt.Sprintf("#%02x%02x%02x", to8(r), to8(g), to8(b))
+}
+
+func rgbToHSL(r, g, b float64) (h, s, l float64) {
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	l = (max + min) / 2
+	if max == min {
+		return 0, 0, l
+	}
+	d := max - min
+	if l > 0.5 {
+		s = d / (2 - max - min)
+	} else {
+		s = d / (max + min)
+	}
+	switch max {
+	case r:
+		h = (g - b) / d
+		if g < b {
+			h += 6
+		}
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	return h / 6, s, l
+}
+
+func hslToRGB(h, s, l float64) (r, g, b float64) {
+	if s == 0 {
+		return l, l, l
+	}
+	var q float64
+	if l < 0.5 {
+		q = l * (1 + s)
+	} else {
+		q = l + s - l*s
+	}
+	p := 2*l - q
+	hue := func(t float64) float64 {
+		if t < 0 {
+			t += 1
+		}
+		if t > 1 {
+			t -= 1
+		}
+		switch {
+		case t < 1.0/6:
+			return p + (q-p)*6*t
+		case t < 1.0/2:
+			return q
+		case t < 2.0/3:
+			return p + (q-p)*(2.0/3-t)*6
+		}
+		return p
+	}
+	return hue(h + 1.0/3), hue(h), hue(h - 1.0/3)
+}
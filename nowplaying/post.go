@@ -0,0 +1,192 @@
+package nowplaying
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// trackPatch is a partial update; only the fields that are set change.
+// It is the TrackPatch schema in openapi.json.
+type trackPatch struct {
+	SongName         *string    `json:"song_name"`
+	Artist           *string    `json:"artist"`
+	CurrentTimestamp *string    `json:"current_timestamp"`
+	EndTimestamp     *string    `json:"end_timestamp"`
+	AlbumArtURL      *string    `json:"album_art_url"`
+	VideoID          *string    `json:"video_id"`
+	CurrentSeconds   *int       `json:"current_seconds"`
+	EndSeconds       *int       `json:"end_seconds"`
+	ProgressPct      *float64   `json:"progress_pct"`
+	Source           string     `json:"source"`
+	At               *time.Time `json:"at"`
+}
+
+// apply merges p into u, the last update from the same source. A timestamp without its seconds field replaces
+// the old seconds too, or they would win over the new timestamp.
+func (p trackPatch) apply(u trackUpdate) trackUpdate {
+	set := func(dst *string, src *string) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	set(&u.SongName, p.SongName)
+	set(&u.Artist, p.Artist)
+	set(&u.VideoID, p.VideoID)
+	if p.CurrentTimestamp != nil {
+		u.CurrentTimestamp, u.CurrentSeconds = *p.CurrentTimestamp, nil
+	}
+	if p.EndTimestamp != nil {
+		u.EndTimestamp, u.EndSeconds = *p.EndTimestamp, nil
+	}
+	if p.CurrentSeconds != nil {
+		u.CurrentSeconds = p.CurrentSeconds
+	}
+	if p.EndSeconds != nil {
+		u.EndSeconds = p.EndSeconds
+	}
+	if p.AlbumArtURL != nil {
+		u.AlbumArtURL = p.AlbumArtURL
+	}
+	if p.ProgressPct != nil {
+		u.ProgressPct = *p.ProgressPct
+	}
+	u.At = p.At
+	return u
+}
+
+var errNothingToPatch = newAPIError(http.StatusConflict, "no_state", "Nothing to patch yet; POST a full track first")
+
+// applyTrack makes u the latest state of its source, which later patches
+// build on, and ingests it. An update stamped earlier than one already
+// applied from the same source is stale and skipped.
+func (h *Handler) applyTrack(u trackUpdate) (bool, *apiError) {
+	h.postMu.Lock()
+	defer h.postMu.Unlock()
+	t, errs := u.normalize()
+	if len(errs) > 0 {
+		return false, invalidFields(errs)
+	}
+	src := h.sources.get(sourceID(u.Source))
+	if src.isStale(u.At) {
+		return false, nil
+	}
+	return h.commitPost(src, u, t, true), nil
+}
+
+// patchTrack merges p into the last state its source posted and ingests
+// the result
+func (h *Handler) patchTrack(p trackPatch) (bool, *apiError) {
+	h.postMu.Lock()
+	defer h.postMu.Unlock()
+	id := sourceID(p.Source)
+	var base trackUpdate
+	if src := h.sources.lookup(id); src != nil {
+		base = src.lastPost
+	}
+	if base.SongName == "" && p.SongName == nil {
+		return false, errNothingToPatch
+	}
+	u := p.apply(base)
+	t, errs := u.normalize()
+	if len(errs) > 0 {
+		return false, invalidFields(errs)
+	}
+	src := h.sources.get(id)
+	if src.isStale(u.At) {
+		return false, nil
+	}
+	return h.commitPost(src, u, t, true), nil
+}
+
+// applyBatch replays buffered updates in the order the client saw them.
+// Each one is a patch on top of the one before from the same source, so a
+// batch can start with a full track and follow with position changes.
+// Nothing is applied unless every update is valid. Only each source's
+// last update fetches art, the earlier ones are over by now.
+func (h *Handler) applyBatch(patches []trackPatch) (applied, skipped int, err *apiError) {
+	var errs []fieldError
+	for i, p := range patches {
+		if p.At == nil {
+			errs = append(errs, fieldError{Field: fmt.Sprintf("updates[%d].at", i), Message: "is required"})
+		}
+	}
+	if len(errs) > 0 {
+		return 0, 0, invalidFields(errs)
+	}
+	order := make([]int, len(patches))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return patches[order[a]].At.Before(*patches[order[b]].At)
+	})
+
+	h.postMu.Lock()
+	defer h.postMu.Unlock()
+
+	// Check every source's chain before touching any state, so a rejected
+	// batch doesn't even create its sources
+	type step struct {
+		id string
+		u  trackUpdate
+		t  NowPlaying
+	}
+	type chain struct {
+		base   trackUpdate
+		newest time.Time
+		// last is the index of the chain's final step
+		last int
+	}
+	var steps []step
+	chains := map[string]*chain{}
+	for _, i := range order {
+		p := patches[i]
+		id := sourceID(p.Source)
+		c := chains[id]
+		if c == nil {
+			c = &chain{}
+			if src := h.sources.lookup(id); src != nil {
+				c.base, c.newest = src.lastPost, src.lastPostAt
+			}
+			chains[id] = c
+		}
+		if !c.newest.IsZero() && p.At.Before(c.newest) {
+			skipped++
+			continue
+		}
+		u := p.apply(c.base)
+		t, fieldErrs := u.normalize()
+		for _, e := range fieldErrs {
+			errs = append(errs, fieldError{Field: fmt.Sprintf("updates[%d].%s", i, e.Field), Message: e.Message})
+		}
+		c.base, c.newest, c.last = u, *p.At, len(steps)
+		steps = append(steps, step{id, u, t})
+	}
+	if len(errs) > 0 {
+		return 0, 0, invalidFields(errs)
+	}
+
+	for n, s := range steps {
+		// Each source's final track is the one still playing
+		if h.commitPost(h.sources.get(s.id), s.u, s.t, n == chains[s.id].last) {
+			applied++
+		} else {
+			skipped++
+		}
+	}
+	return applied, skipped, nil
+}
+
+// commitPost records u as the latest state of src and ingests t. The
+// client's timestamp only orders its updates; liveness goes by when the
+// post arrived, so a client clock that is off can't keep the source live
+// or make it look stale. Callers hold postMu.
+func (h *Handler) commitPost(src *sourceState, u trackUpdate, t NowPlaying, fetchArt bool) bool {
+	src.lastPost = u
+	if u.At != nil {
+		src.lastPostAt = *u.At
+	}
+	return h.ingestFrom(src, t, time.Now(), fetchArt)
+}
@@ -0,0 +1,87 @@
+package nowplaying
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPostClockBehind(t *testing.T) {
+	h := NewHandler(NewStore())
+	defer h.Wait(context.Background())
+	now := time.Now()
+	post := func(source, title string, seconds int, at time.Time) {
+		t.Helper()
+		u := trackUpdate{SongName: title, Artist: "Artist", EndSeconds: ptr(200), CurrentSeconds: ptr(seconds), Source: source, At: &at}
+		if _, err := h.applyTrack(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	post("fast", "A", 10, now)
+	// Started later than fast but stamps its updates 5 minutes behind
+	post("slow", "B", 10, now.Add(-5*time.Minute))
+	post("slow", "B", 11, now.Add(-5*time.Minute+time.Second))
+
+	if v := h.view(); v.State != statePlaying || v.SongName != "B" {
+		t.Errorf("view = %q %q, want the slow source playing", v.State, v.SongName)
+	}
+	for _, s := range h.sourcesStatus().Sources {
+		if s.ID == "slow" && (!s.Active || !s.Playing) {
+			t.Errorf("slow source %+v, want active and playing", s)
+		}
+	}
+	if last := h.store.LastUpdate(); now.Sub(last) > time.Second {
+		t.Errorf("last update %v, want about now", last)
+	}
+	// Its own ordering still holds: an update stamped before the last one
+	// is stale
+	if ok, _ := h.applyTrack(trackUpdate{SongName: "C", Artist: "Artist", Source: "slow", At: ptr(now.Add(-6 * time.Minute))}); ok {
+		t.Error("applied an update older than the last one")
+	}
+}
+
+func TestApplyBatch(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	now := time.Now()
+	at := func(d time.Duration) *time.Time { t := now.Add(d); return &t }
+
+	t.Run("rejected batch creates no sources", func(t *testing.T) {
+		h := NewHandler(NewStore())
+		_, _, err := h.applyBatch([]trackPatch{
+			{SongName: ptr("A"), Artist: ptr("Artist"), Source: "good", At: at(0)},
+			{SongName: ptr("B"), Artist: ptr("Artist"), CurrentTimestamp: ptr("soon"), Source: "bad", At: at(time.Second)},
+		})
+		if err == nil {
+			t.Fatal("want the batch rejected")
+		}
+		if s := h.sourcesStatus().Sources; len(s) != 0 {
+			t.Errorf("sources after a rejected batch: %+v", s)
+		}
+	})
+
+	t.Run("each source's last track fetches art", func(t *testing.T) {
+		h := NewHandler(NewStore(), WithSourceRule(SourcePriority, "first"))
+		defer h.Wait(context.Background())
+		art := srv.URL + "/first.jpg"
+		_, _, err := h.applyBatch([]trackPatch{
+			{SongName: ptr("A"), Artist: ptr("Artist"), AlbumArtURL: ptr(srv.URL + "/old.jpg"), Source: "first", At: at(0)},
+			{SongName: ptr("B"), Artist: ptr("Artist"), AlbumArtURL: ptr(art), Source: "first", At: at(time.Second)},
+			{SongName: ptr("C"), Artist: ptr("Artist"), AlbumArtURL: ptr(srv.URL + "/second.jpg"), Source: "second", At: at(2 * time.Second)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.store.mu.RLock()
+		requested := h.store.requestedArtURL
+		h.store.mu.RUnlock()
+		if v := h.view(); v.SongName != "B" || requested != art {
+			t.Errorf("showing %q with art %q requested, want B with %q", v.SongName, requested, art)
+		}
+	})
+}
+
+func ptr[T any](v T) *T { return &v }
@@ -0,0 +1,190 @@
+package nowplaying
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// privacyMode hides the overlay and drops incoming tracks until it is
+// turned off, times out, or (optionally) the song changes
+type privacyMode struct {
+	store  *Store
+	events *eventLog
+
+	mu             sync.Mutex
+	enabled        bool
+	until          time.Time
+	untilNextTrack bool
+	// track is the raw key of the song playing when privacy was enabled
+	track string
+}
+
+type privacyStatus struct {
+	Enabled        bool       `json:"enabled"`
+	Until          *time.Time `json:"until,omitempty"`
+	UntilNextTrack bool       `json:"until_next_track,omitempty"`
+}
+
+// privacyRequest is the POST body. Without a body POST toggles.
+type privacyRequest struct {
+	Enabled        *bool  `json:"enabled"`
+	Timeout        string `json:"timeout"`
+	UntilNextTrack bool   `json:"until_next_track"`
+}
+
+func (p *privacyMode) set(enabled bool, timeout time.Duration, untilNextTrack bool) {
+	last := p.store.LastIncoming()
+	artist, title := last.RawArtist, last.RawSongName
+	if title == "" {
+		artist, title = last.Artist, last.SongName
+	}
+
+	p.mu.Lock()
+	was := p.enabled
+	p.enabled = enabled
+	p.until = time.Time{}
+	p.untilNextTrack = enabled && untilNextTrack
+	p.track = trackKey(artist, title)
+	if enabled && timeout > 0 {
+		p.until = time.Now().Add(timeout)
+	}
+	p.mu.Unlock()
+
+	if enabled {
+		// Blank the overlay now rather than at the next post. Privacy is
+		// already on when subscribers hear of it, so a relay woken by this
+		// holds its art back.
+		p.store.setTrack(NowPlaying{})
+	}
+	if was != enabled {
+		if enabled {
+			p.events.record("privacy", "privacy mode on")
+		} else {
+			p.events.record("privacy", "privacy mode off")
+		}
+	}
+}
+
+// active reports whether privacy mode is on, turning it off once its
+// timeout has passed
+func (p *privacyMode) active() bool {
+	p.mu.Lock()
+	expired := p.enabled && !p.until.IsZero() && time.Now().After(p.until)
+	if expired {
+		p.enabled = false
+	}
+	enabled := p.enabled
+	p.mu.Unlock()
+	if expired {
+		p.events.record("privacy", "privacy mode timed out")
+	}
+	return enabled
+}
+
+// holds reports whether an incoming track must be dropped. In
+// until-next-track mode a different song ends privacy mode.
+func (p *privacyMode) holds(t NowPlaying) bool {
+	if !p.active() {
+		return false
+	}
+	p.mu.Lock()
+	changed := p.untilNextTrack && trackKey(t.Artist, t.SongName) != p.track
+	if changed {
+		p.enabled = false
+	}
+	p.mu.Unlock()
+	if changed {
+		p.events.record("privacy", "privacy mode off, track changed")
+		return false
+	}
+	return true
+}
+
+func (p *privacyMode) status() privacyStatus {
+	enabled := p.active()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := privacyStatus{Enabled: enabled, UntilNextTrack: enabled && p.untilNextTrack}
+	if enabled && !p.until.IsZero() {
+		until := p.until
+		s.Until = &until
+	}
+	return s
+}
+
+// privacyHandler serves GET/POST /api/privacy, plus GET /api/privacy/on,
+// /off and /toggle for hotkey tools that can only open a URL. The GET
+// variants take ?timeout=10m and ?next=1 (off at the next track).
+func (h *Handler) privacyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	action := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/privacy"), "/")
+	if err := h.changePrivacy(w, r, action, false); err != nil {
+		err.writeLegacy(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.privacy.status())
+}
+
+// changePrivacy carries out a privacy request; action is the path after
+// /privacy. strict checks POST bodies against the PrivacyRequest schema.
+func (h *Handler) changePrivacy(w http.ResponseWriter, r *http.Request, action string, strict bool) *apiError {
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+	case action == "" && r.Method == http.MethodPost:
+		var req privacyRequest
+		if strict {
+			if err := decodeJSON(w, r, "PrivacyRequest", &req, true); err != nil {
+				return err
+			}
+		} else if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				return newAPIError(http.StatusBadRequest, "invalid_json", "Invalid JSON")
+			}
+		}
+		timeout, err := parsePrivacyTimeout(req.Timeout)
+		if err != nil {
+			return errInvalidTimeout
+		}
+		enabled := !h.privacy.status().Enabled
+		if req.Enabled != nil {
+			enabled = *req.Enabled
+		}
+		h.privacy.set(enabled, timeout, req.UntilNextTrack)
+	case action == "on" || action == "off" || action == "toggle":
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			return errMethodNotAllowed
+		}
+		q := r.URL.Query()
+		timeout, err := parsePrivacyTimeout(q.Get("timeout"))
+		if err != nil {
+			return errInvalidTimeout
+		}
+		enabled := action == "on" || (action == "toggle" && !h.privacy.status().Enabled)
+		h.privacy.set(enabled, timeout, q.Get("next") == "1" || q.Get("next") == "true")
+	case action == "":
+		return errMethodNotAllowed
+	default:
+		return newAPIError(http.StatusNotFound, "not_found", "Unknown privacy action")
+	}
+	return nil
+}
+
+var errInvalidTimeout = newAPIError(http.StatusBadRequest, "invalid_timeout", "Invalid timeout")
+
+func parsePrivacyTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
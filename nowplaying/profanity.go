@@ -0,0 +1,177 @@
+package nowplaying
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Characters commonly typed in place of letters
+var leetLetters = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '9': 'g',
+	'@': 'a', '$': 's',
+}
+
+// WordMask hides listed words in displayed text. The stored track keeps
+// the real values; masking only happens on the way out.
+type WordMask struct {
+	char rune
+	// words holds whole words, prefixes words listed as "word*"; each in
+	// folded and in collapsed form
+	words    map[string]bool
+	prefixes []string
+
+	collapsedWords    map[string]bool
+	collapsedPrefixes []string
+}
+
+// LoadWordMask reads a word list, one word per line. Lines starting with
+// # are comments, and a trailing * also matches longer words.
+func LoadWordMask(path string, char string) (*WordMask, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := &WordMask{char: '*', words: map[string]bool{}, collapsedWords: map[string]bool{}}
+	if r, _ := utf8.DecodeRuneInString(char); r != utf8.RuneError {
+		m.char = r
+	}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if w, ok := strings.CutSuffix(line, "*"); ok {
+			if w = foldWord(w); w != "" {
+				m.prefixes = append(m.prefixes, w)
+				m.collapsedPrefixes = append(m.collapsedPrefixes, collapseRepeats(w))
+			}
+		} else if w := foldWord(line); w != "" {
+			m.words[w] = true
+			m.collapsedWords[collapseRepeats(w)] = true
+		}
+	}
+	return m, sc.Err()
+}
+
+// foldWord folds case, accents and leetspeak, so "Fück" and "F4CK" both
+// compare equal to "fuck"
+func foldWord(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if l, ok := leetLetters[r]; ok {
+			r = l
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// collapseRepeats turns "fuuuck" into "fuck"
+func collapseRepeats(s string) string {
+	var b strings.Builder
+	var last rune
+	for _, r := range s {
+		if r != last {
+			b.WriteRune(r)
+		}
+		last = r
+	}
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	_, leet := leetLetters[r]
+	return leet || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+func (m *WordMask) matches(word string) bool {
+	if strings.IndexFunc(word, unicode.IsLetter) < 0 {
+		return false
+	}
+	w := foldWord(word)
+	if m.words[w] || hasAnyPrefix(w, m.prefixes) {
+		return true
+	}
+	// Only stretched words are compared in collapsed form, otherwise a
+	// listed "ass" would also hide "as"
+	if c := collapseRepeats(w); c != w {
+		return m.collapsedWords[c] || hasAnyPrefix(c, m.collapsedPrefixes)
+	}
+	return false
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Mask replaces every listed word in s, keeping its first letter
+func (m *WordMask) Mask(s string) string {
+	var b strings.Builder
+	rest := s
+	for rest != "" {
+		i := strings.IndexFunc(rest, isWordRune)
+		if i < 0 {
+			b.WriteString(rest)
+			break
+		}
+		b.WriteString(rest[:i])
+		rest = rest[i:]
+		end := strings.IndexFunc(rest, func(r rune) bool { return !isWordRune(r) })
+		if end < 0 {
+			end = len(rest)
+		}
+		word := rest[:end]
+		rest = rest[end:]
+		if !m.matches(word) {
+			b.WriteString(word)
+			continue
+		}
+		for j, r := range word {
+			if j == 0 {
+				b.WriteRune(r)
+			} else if !unicode.Is(unicode.Mn, r) {
+				b.WriteRune(m.char)
+			}
+		}
+	}
+	return b.String()
+}
+
+// display returns the track as it is shown and handed to sinks, with
+// listed words masked everywhere, the raw values included, since chat
+// bots and file outputs read those too.
+func (h *Handler) display(t NowPlaying) NowPlaying {
+	profanity := h.mask
+	if profanity == nil {
+		return t
+	}
+	t.SongName = profanity.Mask(t.SongName)
+	t.Artist = profanity.Mask(t.Artist)
+	t.Album = profanity.Mask(t.Album)
+	t.RawSongName = profanity.Mask(t.RawSongName)
+	t.RawArtist = profanity.Mask(t.RawArtist)
+	if len(t.FeaturedArtists) > 0 {
+		featured := make([]string, len(t.FeaturedArtists))
+		for i, a := range t.FeaturedArtists {
+			featured[i] = profanity.Mask(a)
+		}
+		t.FeaturedArtists = featured
+	}
+	return t
+}
@@ -0,0 +1,67 @@
+package nowplaying
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testWordMask(t *testing.T, words string) *WordMask {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte(words), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadWordMask(path, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestWordMask(t *testing.T) {
+	m := testWordMask(t, "# comment\ndarn\nheck*\n")
+	tests := map[string]string{
+		"Darn It":             "D*** It",
+		"d4rn":                "d***",
+		"daaarn you":          "d***** you",
+		"Heckin Good":         "H***** Good",
+		"Dárn":                "D***",
+		"darnation":           "darnation",
+		"check this":          "check this",
+		"It's darn-good, ok?": "It's d***-good, ok?",
+	}
+	for in, want := range tests {
+		if got := m.Mask(in); got != want {
+			t.Errorf("Mask(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestDisplayMasksRawFields(t *testing.T) {
+	h := &Handler{mask: testWordMask(t, "darn\n")}
+	in := NowPlaying{
+		SongName:        "Darn (Official Video)",
+		Artist:          "Darn Band",
+		Album:           "Darn",
+		FeaturedArtists: []string{"MC Darn"},
+		RawSongName:     "Darn Band - Darn (Official Video)",
+		RawArtist:       "DarnBandVEVO darn",
+	}
+	got := h.display(in)
+	want := NowPlaying{
+		SongName:        "D*** (Official Video)",
+		Artist:          "D*** Band",
+		Album:           "D***",
+		FeaturedArtists: []string{"MC D***"},
+		RawSongName:     "D*** Band - D*** (Official Video)",
+		RawArtist:       "DarnBandVEVO d***",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("display =\n%+v\nwant\n%+v", got, want)
+	}
+	if in.FeaturedArtists[0] != "MC Darn" {
+		t.Error("display changed the caller's featured artists")
+	}
+}
@@ -0,0 +1,246 @@
+package nowplaying
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A relay streams one instance's state and art to another over a single
+// long-lived POST to /api/v1/relay, one JSON message per line. The
+// receiving instance serves the overlay as if the tracks were posted to it.
+
+const (
+	// The state is resent this often, which doubles as a keepalive.
+	// Turning privacy mode on notifies subscribers with a blank track, but
+	// turning it off or letting it time out doesn't, so the end of privacy
+	// mode and any art held back during it reach the target within this
+	// time.
+	relayKeepalive  = 2 * time.Second
+	relayMaxBackoff = 30 * time.Second
+	// Art is sent inline, so a line may be a few MB
+	maxRelayLine = 16 << 20
+)
+
+type relayMessage struct {
+	Type       string      `json:"type"`
+	Track      *NowPlaying `json:"track,omitempty"`
+	LastUpdate *time.Time  `json:"last_update,omitempty"`
+	Art        *relayArt   `json:"art,omitempty"`
+}
+
+type relayArt struct {
+	URL         string `json:"url"`
+	Track       string `json:"track,omitempty"`
+	ContentType string `json:"content_type"`
+	Data        []byte `json:"data"`
+}
+
+// RunRelay forwards the handler's state and art to the instance at
+// target, such as http://stream-pc:17890 or a tenant's
+// http://box:17890/s/alice, until ctx is done. token is the target's
+// token. A dropped connection is retried with backoff, and every new
+// connection starts with the full state so the target catches up.
+func (h *Handler) RunRelay(ctx context.Context, target, token string) error {
+	target = strings.TrimRight(target, "/")
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := h.relayOnce(ctx, target, token)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if time.Since(start) > time.Minute {
+			backoff = time.Second
+		}
+		h.events.record("relay", "Relay to %s lost (%v), retrying in %s", target, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, relayMaxBackoff)
+	}
+}
+
+// relayOnce runs one relay connection until it fails or ctx is done
+func (h *Handler) relayOnce(ctx context.Context, target, token string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target+"/api/v1/relay", pr)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	done := make(chan error, 1)
+	connected := make(chan struct{})
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			if resp.StatusCode != http.StatusOK {
+				var body struct {
+					Error *apiError `json:"error"`
+				}
+				json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&body)
+				err = fmt.Errorf("%s", resp.Status)
+				if body.Error != nil {
+					err = fmt.Errorf("%s: %s", resp.Status, body.Error.Message)
+				}
+			} else {
+				close(connected)
+				// The target answers right away and then holds the
+				// response open for as long as it reads
+				_, err = io.Copy(io.Discard, resp.Body)
+				if err == nil {
+					err = errors.New("closed by target")
+				}
+			}
+			resp.Body.Close()
+		}
+		pr.CloseWithError(err)
+		done <- err
+	}()
+
+	updates, unsubscribe := h.store.Subscribe()
+	defer unsubscribe()
+	enc := json.NewEncoder(pw)
+	sentArt := -1
+	send := func() error {
+		if err := enc.Encode(h.relayState()); err != nil {
+			return err
+		}
+		// Art would give away the track in privacy mode; the latest is
+		// sent with the first state after it ends
+		if h.privacy.active() {
+			return nil
+		}
+		if art := h.store.Art(); art.Version != sentArt && len(art.Data) > 0 {
+			if err := enc.Encode(relayMessage{Type: "art", Art: &relayArt{
+				URL: art.URL, Track: art.Track, ContentType: art.ContentType, Data: art.Data,
+			}}); err != nil {
+				return err
+			}
+			sentArt = art.Version
+		}
+		return nil
+	}
+
+	// Everything first, so a reconnect resyncs the target
+	if err := send(); err != nil {
+		return <-done
+	}
+	select {
+	case <-connected:
+		h.events.record("relay", "Relaying to %s", target)
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	keepalive := time.NewTicker(relayKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-done:
+			return err
+		case <-updates:
+		case <-keepalive.C:
+		}
+		if err := send(); err != nil {
+			return <-done
+		}
+	}
+}
+
+// relayState is the current track as the target should store it. In
+// privacy mode or once the source stopped the target gets nothing
+// playing, as a local overlay would show.
+func (h *Handler) relayState() relayMessage {
+	t := h.store.Track()
+	if h.privacy.active() || h.store.isStopped() {
+		t = NowPlaying{}
+	}
+	m := relayMessage{Type: "state", Track: &t}
+	if last := h.store.LastUpdate(); !last.IsZero() {
+		m.LastUpdate = &last
+	}
+	return m
+}
+
+var errRelayDisabled = newAPIError(http.StatusForbidden, "relay_disabled", "This instance needs a token to accept a relay")
+
+// apiRelayHandler takes a relay stream from another instance and applies
+// it until the sender goes away
+func (h *Handler) apiRelayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errMethodNotAllowed.write(w)
+		return
+	}
+	if h.token == "" {
+		errRelayDisabled.write(w)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/x-ndjson") {
+		newAPIError(http.StatusUnsupportedMediaType, "unsupported_media_type", "Content-Type must be application/x-ndjson").write(w)
+		return
+	}
+
+	// Answer before reading so the sender knows it got in
+	rc := http.NewResponseController(w)
+	if err := rc.EnableFullDuplex(); err != nil {
+		slog.Debug("relay full duplex", "err", err)
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	h.events.record("relay", "Relay connected from %s", r.RemoteAddr)
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 64<<10), maxRelayLine)
+	for scanner.Scan() {
+		var m relayMessage
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			slog.Warn("invalid relay message", "remote", r.RemoteAddr, "err", err)
+			continue
+		}
+		h.applyRelay(m)
+	}
+	err := scanner.Err()
+	if err == nil {
+		err = io.EOF
+	}
+	h.events.record("relay", "Relay from %s disconnected (%v)", r.RemoteAddr, err)
+}
+
+// applyRelay stores what the sender already cleaned up, overrode and
+// fetched, so none of that runs again here
+func (h *Handler) applyRelay(m relayMessage) {
+	switch {
+	case m.Type == "state" && m.Track != nil:
+		if m.LastUpdate != nil {
+			h.store.touchAt(*m.LastUpdate)
+		}
+		t := *m.Track
+		h.store.setLastIncoming(t)
+		if changed, _ := h.store.publish(t, ""); changed && t.SongName != "" {
+			h.metrics.trackChanges.Inc("")
+			slog.Info("track changed", "artist", t.Artist, "title", t.SongName, "album", t.Album, "via", "relay")
+		}
+	case m.Type == "art" && m.Art != nil && len(m.Art.Data) > 0:
+		h.store.setArt("", m.Art.URL, m.Art.Track, m.Art.Data, m.Art.ContentType)
+	}
+}
@@ -0,0 +1,52 @@
+package nowplaying
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRelayHoldsArtInPrivacyMode(t *testing.T) {
+	target := NewHandler(NewStore(), WithToken("secret"))
+	srv := httptest.NewServer(target)
+	defer srv.Close()
+	received, stop := target.store.Subscribe()
+	defer stop()
+	// next returns the next update the target applied from the relay
+	next := func() Update {
+		t.Helper()
+		select {
+		case u := <-received:
+			return u
+		case <-time.After(10 * time.Second):
+			t.Fatal("nothing relayed")
+			return Update{}
+		}
+	}
+
+	h := NewHandler(NewStore())
+	h.store.setArt("", "https://example.com/a.jpg", "", []byte("art"), "image/jpeg")
+	h.privacy.set(true, 0, false)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.RunRelay(ctx, srv.URL, "secret")
+
+	// The first send is the state, followed by the art if it goes out. A
+	// second state after it means the target has read everything before.
+	if u := next(); u.ArtVersion != 0 {
+		t.Fatalf("art relayed in privacy mode (version %d)", u.ArtVersion)
+	}
+	h.store.setTrack(NowPlaying{})
+	if u := next(); u.ArtVersion != 0 {
+		t.Fatalf("art relayed in privacy mode (version %d)", u.ArtVersion)
+	}
+
+	h.privacy.set(false, 0, false)
+	h.store.setTrack(NowPlaying{})
+	for next().ArtVersion == 0 {
+	}
+	if got := string(target.store.Art().Data); got != "art" {
+		t.Errorf("relayed art %q", got)
+	}
+}
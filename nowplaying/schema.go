@@ -0,0 +1,187 @@
+package nowplaying
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//go:embed openapi.json
+var openAPIDoc []byte
+
+// schema is the subset of JSON Schema that openapi.json uses for request
+// bodies
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Nullable             bool               `json:"nullable"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *schema            `json:"items"`
+	Enum                 []any              `json:"enum"`
+	MaxLength            *int               `json:"maxLength"`
+	MaxItems             *int               `json:"maxItems"`
+	Format               string             `json:"format"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+}
+
+var apiSchemas = mustLoadSchemas(openAPIDoc)
+
+func mustLoadSchemas(doc []byte) map[string]*schema {
+	var spec struct {
+		Components struct {
+			Schemas map[string]*schema `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(doc, &spec); err != nil {
+		panic("openapi.json: " + err.Error())
+	}
+	return spec.Components.Schemas
+}
+
+const msgUnknownField = "is not a known field"
+
+// fieldError is one problem with a request body. Field is a dotted path
+// such as "track.song_name", empty for the body itself.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validateSchema checks a value decoded with UseNumber against the named
+// schema from openapi.json
+func validateSchema(name string, v any) []fieldError {
+	var errs []fieldError
+	apiSchemas[name].validate("", v, &errs)
+	return errs
+}
+
+func (s *schema) resolve() *schema {
+	for s != nil && s.Ref != "" {
+		s = apiSchemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	return s
+}
+
+func (s *schema) validate(path string, v any, errs *[]fieldError) {
+	s = s.resolve()
+	if s == nil || (v == nil && s.Nullable) {
+		return
+	}
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, fieldError{Field: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			fail("must be an object")
+			return
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				*errs = append(*errs, fieldError{Field: joinField(path, name), Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					*errs = append(*errs, fieldError{Field: joinField(path, name), Message: msgUnknownField})
+				}
+				continue
+			}
+			prop.validate(joinField(path, name), obj[name], errs)
+		}
+		return
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			fail("must be an array")
+			return
+		}
+		if s.MaxItems != nil && len(arr) > *s.MaxItems {
+			fail("must have at most %d items", *s.MaxItems)
+			return
+		}
+		for i, item := range arr {
+			s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+		}
+		return
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			fail("must be a string")
+			return
+		}
+		if s.MaxLength != nil && utf8.RuneCountInString(str) > *s.MaxLength {
+			fail("must be at most %d characters", *s.MaxLength)
+		}
+		if _, err := time.Parse(time.RFC3339, str); s.Format == "date-time" && err != nil {
+			fail("must be an RFC 3339 date-time")
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			fail("must be true or false")
+			return
+		}
+	case "integer", "number":
+		n, ok := v.(json.Number)
+		if !ok {
+			fail("must be a number")
+			return
+		}
+		f, err := n.Float64()
+		if err != nil {
+			fail("must be a number")
+			return
+		}
+		if s.Type == "integer" {
+			if _, err := n.Int64(); err != nil {
+				fail("must be an integer")
+				return
+			}
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			fail("must be at least %g", *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			fail("must be at most %g", *s.Maximum)
+		}
+	}
+	if len(s.Enum) > 0 && !enumContains(s.Enum, v) {
+		opts := make([]string, len(s.Enum))
+		for i, e := range s.Enum {
+			opts[i] = fmt.Sprint(e)
+		}
+		fail("must be one of %s", strings.Join(opts, ", "))
+	}
+}
+
+func enumContains(enum []any, v any) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func joinField(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
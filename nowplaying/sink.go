@@ -0,0 +1,38 @@
+package nowplaying
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const sinkTimeout = 10 * time.Second
+
+// startSink delivers each new track to s from its own goroutine, so a
+// slow sink neither holds up the others nor the store
+func (h *Handler) startSink(s Sink) {
+	updates, stop := h.store.Subscribe()
+	h.sinkStops = append(h.sinkStops, stop)
+	h.sinkWG.Add(1)
+	go func() {
+		defer h.sinkWG.Done()
+		for u := range updates {
+			t := u.Track
+			if !u.TrackChanged || t.SongName == "" || t.Blocked || t.Hidden {
+				continue
+			}
+			h.deliver(s, h.display(t))
+		}
+	}()
+}
+
+func (h *Handler) deliver(s Sink, t NowPlaying) {
+	ctx, cancel := context.WithTimeout(context.Background(), sinkTimeout)
+	defer cancel()
+	if err := s.Deliver(ctx, t); err != nil {
+		h.metrics.sinkFailures.Inc(s.Name())
+		slog.Warn("sink delivery failed", "sink", s.Name(), "artist", t.Artist, "title", t.SongName, "err", err)
+		return
+	}
+	h.metrics.sinkDeliveries.Inc(s.Name())
+}
@@ -0,0 +1,339 @@
+package nowplaying
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SourceRule decides which source the overlay follows when several are
+// playing at once
+type SourceRule string
+
+const (
+	// SourceRecent follows the source that most recently started playing
+	SourceRecent SourceRule = "recent"
+	// SourcePriority follows the first playing source in the priority list
+	SourcePriority SourceRule = "priority"
+)
+
+func ParseSourceRule(s string) (SourceRule, error) {
+	switch r := SourceRule(s); r {
+	case SourceRecent, SourcePriority:
+		return r, nil
+	}
+	return "", fmt.Errorf("unknown source rule %q (use recent or priority)", s)
+}
+
+// WithSourceRule sets how the active source is picked. Priority entries
+// match a source ID exactly or as its prefix before a dash, so "ytmusic"
+// covers "ytmusic-3f9a". The default follows the most recent source.
+func WithSourceRule(rule SourceRule, priority ...string) Option {
+	return func(h *Handler) {
+		h.sources.rule, h.sources.priority = rule, priority
+	}
+}
+
+const (
+	// defaultSourceID is used for updates that don't name their source
+	defaultSourceID = "default"
+	// A source whose position hasn't moved for this long is paused
+	sourcePlayingWindow = 5 * time.Second
+	// Sources that stay silent this long are forgotten
+	sourceForgetAfter = 10 * time.Minute
+)
+
+// sourceID is the source an update belongs to
+func sourceID(s string) string {
+	if s = strings.TrimSpace(s); s == "" {
+		return defaultSourceID
+	}
+	return s
+}
+
+// sourceState is what one source last reported
+type sourceState struct {
+	id string
+	// lastPost is the latest update as sent, which PATCH builds on, and
+	// lastPostAt the newest client timestamp applied
+	lastPost   trackUpdate
+	lastPostAt time.Time
+
+	track    NowPlaying
+	lastSeen time.Time
+	// lastProgress is when the position last moved, playingSince when it
+	// started moving after a pause
+	lastProgress time.Time
+	playingSince time.Time
+}
+
+func (s *sourceState) playing(now time.Time) bool {
+	return !s.lastProgress.IsZero() && now.Sub(s.lastProgress) <= sourcePlayingWindow
+}
+
+// isStale reports whether an update stamped at is older than the newest
+// one applied. Updates without a stamp are never stale.
+func (s *sourceState) isStale(at *time.Time) bool {
+	return at != nil && !s.lastPostAt.IsZero() && at.Before(s.lastPostAt)
+}
+
+// note records that the source reported t at the given time. A new track
+// or a moving position counts as playing; sources that never send a
+// position count every track they send.
+func (s *sourceState) note(t NowPlaying, at time.Time) {
+	moved := t.CurrentSeconds != s.track.CurrentSeconds ||
+		trackKey(t.Artist, t.SongName) != trackKey(s.track.Artist, s.track.SongName) ||
+		(t.CurrentSeconds == 0 && t.EndSeconds == 0)
+	if t.SongName != "" && t.Artist != "" && moved {
+		if !s.playing(at) {
+			s.playingSince = at
+		}
+		s.lastProgress = at
+	}
+	s.track = t
+	if at.After(s.lastSeen) {
+		s.lastSeen = at
+	}
+}
+
+// sourceArbiter keeps per-source state and picks the active source. The
+// Handler's postMu guards it.
+type sourceArbiter struct {
+	rule     SourceRule
+	priority []string
+	sources  map[string]*sourceState
+	active   string
+	pinned   string
+}
+
+// lookup returns the source's state, or nil for a source never seen
+func (a *sourceArbiter) lookup(id string) *sourceState {
+	return a.sources[id]
+}
+
+// get returns the source's state, creating it on first use
+func (a *sourceArbiter) get(id string) *sourceState {
+	if a.sources == nil {
+		a.sources = map[string]*sourceState{}
+	}
+	s := a.sources[id]
+	if s == nil {
+		s = &sourceState{id: id}
+		a.sources[id] = s
+	}
+	return s
+}
+
+// rank is a source's place in the priority list; unlisted sources come
+// after all listed ones
+func (a *sourceArbiter) rank(id string) int {
+	for i, p := range a.priority {
+		if id == p || strings.HasPrefix(id, p+"-") {
+			return i
+		}
+	}
+	return len(a.priority)
+}
+
+// better reports whether s should win over other when both are playing
+func (a *sourceArbiter) better(s, other *sourceState) bool {
+	if a.rule == SourcePriority {
+		if rs, ro := a.rank(s.id), a.rank(other.id); rs != ro {
+			return rs < ro
+		}
+	}
+	if !s.playingSince.Equal(other.playingSince) {
+		return s.playingSince.After(other.playingSince)
+	}
+	return s.id < other.id
+}
+
+// pick returns the source the overlay should follow. A pinned source wins
+// while it keeps posting. Otherwise the rule decides among the playing
+// sources; when none is playing the active source stays while it is still
+// heard from, so a pause doesn't hand the overlay to another tab.
+func (a *sourceArbiter) pick(now time.Time, liveFor time.Duration) string {
+	live := func(s *sourceState) bool {
+		return s != nil && (liveFor <= 0 || now.Sub(s.lastSeen) <= liveFor)
+	}
+	for id, s := range a.sources {
+		if now.Sub(s.lastSeen) > sourceForgetAfter && id != a.active {
+			delete(a.sources, id)
+		}
+	}
+	if s := a.sources[a.pinned]; live(s) {
+		return s.id
+	}
+
+	var best *sourceState
+	for _, s := range a.sources {
+		if s.playing(now) && (best == nil || a.better(s, best)) {
+			best = s
+		}
+	}
+	if best != nil {
+		return best.id
+	}
+	if s := a.sources[a.active]; live(s) {
+		return s.id
+	}
+	for _, s := range a.sources {
+		if best == nil || s.lastSeen.After(best.lastSeen) || (s.lastSeen.Equal(best.lastSeen) && s.id < best.id) {
+			best = s
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.id
+}
+
+// ingestFrom records t as what src reports and ingests it if src is the
+// active source. When another source takes over, its latest track is
+// ingested instead. Callers hold postMu.
+func (h *Handler) ingestFrom(src *sourceState, t NowPlaying, at time.Time, fetchArt bool) bool {
+	t.Source = src.id
+	src.note(t, at)
+	if h.arbitrate(src.id) != src.id {
+		if t.SongName == "" || t.Artist == "" {
+			h.health.noteIgnored()
+			return false
+		}
+		return true
+	}
+	return h.ingestAt(t, at, fetchArt)
+}
+
+// stopSource records that a source stopped playing. If it was the one on
+// the overlay, another playing source takes over, or the overlay goes
+// idle right away instead of once the source is stale.
+func (h *Handler) stopSource(id string) {
+	h.postMu.Lock()
+	defer h.postMu.Unlock()
+	src := h.sources.get(id)
+	src.note(NowPlaying{Source: id}, time.Now())
+	src.lastProgress, src.playingSince = time.Time{}, time.Time{}
+	if h.arbitrate(id) == id {
+		h.store.stop()
+	}
+}
+
+// arbitrate picks the active source again and reports it. A switch to a
+// source other than caller makes its latest track current; the caller
+// ingests its own. Callers hold postMu.
+func (h *Handler) arbitrate(caller string) string {
+	a := &h.sources
+	prev := a.active
+	a.active = a.pick(time.Now(), h.staleAfter)
+	if a.active == prev || a.active == "" {
+		return a.active
+	}
+	h.metrics.sourceSwitches.Inc(a.active)
+	if prev != "" {
+		h.events.record("source", "Switched from source %s to %s", prev, a.active)
+	}
+	if s := a.sources[a.active]; a.active != caller && s.track.SongName != "" {
+		h.ingestAt(s.track, s.lastSeen, true)
+	}
+	return a.active
+}
+
+type sourceInfo struct {
+	ID       string    `json:"id"`
+	Active   bool      `json:"active"`
+	Pinned   bool      `json:"pinned"`
+	Playing  bool      `json:"playing"`
+	LastSeen time.Time `json:"last_seen"`
+	SongName string    `json:"song_name"`
+	Artist   string    `json:"artist"`
+}
+
+type sourcesStatus struct {
+	Rule     SourceRule   `json:"rule"`
+	Priority []string     `json:"priority"`
+	Active   string       `json:"active,omitempty"`
+	Pinned   string       `json:"pinned,omitempty"`
+	Sources  []sourceInfo `json:"sources"`
+}
+
+func (h *Handler) sourcesStatus() sourcesStatus {
+	h.postMu.Lock()
+	defer h.postMu.Unlock()
+	a := &h.sources
+	now := time.Now()
+	s := sourcesStatus{Rule: a.rule, Priority: a.priority, Active: a.active, Pinned: a.pinned, Sources: []sourceInfo{}}
+	if s.Priority == nil {
+		s.Priority = []string{}
+	}
+	for _, src := range a.sources {
+		s.Sources = append(s.Sources, sourceInfo{
+			ID:       src.id,
+			Active:   src.id == a.active,
+			Pinned:   src.id == a.pinned,
+			Playing:  src.playing(now),
+			LastSeen: src.lastSeen,
+			SongName: src.track.SongName,
+			Artist:   src.track.Artist,
+		})
+	}
+	sort.Slice(s.Sources, func(i, j int) bool { return s.Sources[i].ID < s.Sources[j].ID })
+	return s
+}
+
+// pinSource makes the overlay follow id while it posts; an empty id
+// hands the choice back to the rule
+func (h *Handler) pinSource(id string) {
+	h.postMu.Lock()
+	defer h.postMu.Unlock()
+	id = strings.TrimSpace(id)
+	if id == h.sources.pinned {
+		return
+	}
+	h.sources.pinned = id
+	if id == "" {
+		h.events.record("source", "Unpinned source")
+	} else {
+		h.events.record("source", "Pinned source %s", id)
+	}
+	h.arbitrate("")
+}
+
+func (h *Handler) apiSourcesHandler(w http.ResponseWriter, r *http.Request) {
+	if apiCORS(w, r, "GET") {
+		return
+	}
+	if r.Method != http.MethodGet {
+		errMethodNotAllowed.write(w)
+		return
+	}
+	writeData(w, h.sourcesStatus())
+}
+
+func (h *Handler) apiSourcePinHandler(w http.ResponseWriter, r *http.Request) {
+	if apiCORS(w, r, "POST, DELETE") {
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		var pin struct {
+			Source string `json:"source"`
+		}
+		if err := decodeJSON(w, r, "SourcePin", &pin, false); err != nil {
+			err.write(w)
+			return
+		}
+		if strings.TrimSpace(pin.Source) == "" {
+			invalidFields([]fieldError{{Field: "source", Message: "must not be empty"}}).write(w)
+			return
+		}
+		h.pinSource(pin.Source)
+	case http.MethodDelete:
+		h.pinSource("")
+	default:
+		errMethodNotAllowed.write(w)
+		return
+	}
+	writeData(w, h.sourcesStatus())
+}
@@ -0,0 +1,52 @@
+package nowplaying
+
+import (
+	"context"
+	"testing"
+)
+
+// sourceFunc runs a function as a Source
+type sourceFunc func(ctx context.Context, emit func(NowPlaying)) error
+
+func (f sourceFunc) Run(ctx context.Context, emit func(NowPlaying)) error { return f(ctx, emit) }
+
+func TestStopSource(t *testing.T) {
+	h := NewHandler(NewStore())
+	defer h.Wait(context.Background())
+	stop := func(id string) {
+		h.RunSource(context.Background(), sourceFunc(func(_ context.Context, emit func(NowPlaying)) error {
+			emit(NowPlaying{Source: id})
+			return nil
+		}))
+	}
+
+	h.Ingest(NowPlaying{Source: "file", SongName: "A", Artist: "Artist"})
+	if v := h.view(); v.State != statePlaying || v.SongName != "A" {
+		t.Fatalf("after a track: %+v", v)
+	}
+	stop("file")
+	if v := h.view(); v.State != stateIdle {
+		t.Fatalf("after a stop: state %q, want idle", v.State)
+	}
+	if tr := h.relayState().Track; tr.SongName != "" {
+		t.Errorf("relay still sends %q after a stop", tr.SongName)
+	}
+	h.Ingest(NowPlaying{Source: "file", SongName: "B", Artist: "Artist"})
+	if v := h.view(); v.State != statePlaying || v.SongName != "B" {
+		t.Fatalf("after the next track: %+v", v)
+	}
+
+	// Another playing source takes over from one that stops
+	h.Ingest(NowPlaying{Source: "tab", SongName: "C", Artist: "Artist"})
+	h.Ingest(NowPlaying{Source: "file", SongName: "B", Artist: "Artist", CurrentSeconds: 1})
+	stop("file")
+	if v := h.view(); v.State != statePlaying || v.SongName != "C" {
+		t.Errorf("after the active source stopped: %+v, want the other source's track", v)
+	}
+
+	// A source that isn't shown stopping changes nothing
+	stop("elsewhere")
+	if v := h.view(); v.State != statePlaying || v.SongName != "C" {
+		t.Errorf("after another source stopped: %+v", v)
+	}
+}
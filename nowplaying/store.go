@@ -0,0 +1,340 @@
+package nowplaying
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// Art is the cached album art of the current track
+type Art struct {
+	// URL is where the art came from; Track is the trackKey it belongs to
+	// when it didn't come from the track's own URL
+	URL         string
+	Track       string
+	Data        []byte
+	ContentType string
+	// Version goes up with every new image so clients can bust caches
+	Version int
+	Palette *Palette
+}
+
+// Update is sent to subscribers whenever the track or the art changes
+type Update struct {
+	Track NowPlaying
+	// TrackChanged is set when a different song started, rather than the
+	// same one progressing
+	TrackChanged bool
+	ArtVersion   int
+}
+
+// Store owns the current track and album art and tells subscribers about
+// changes. It is safe for concurrent use.
+type Store struct {
+	mu    sync.RWMutex
+	track NowPlaying
+	// lastIncoming is the latest track after cleanup but before overrides
+	// and library enrichment
+	lastIncoming NowPlaying
+	// lastUpdate is when the source last posted a track
+	lastUpdate time.Time
+	// requestedArtURL is the art source last asked for, which may have
+	// failed, unlike art.URL
+	requestedArtURL string
+	art             Art
+	// stopped is set when the active source said nothing is playing any
+	// more; the next track clears it
+	stopped bool
+
+	subsMu sync.Mutex
+	subs   map[*subscriber]struct{}
+}
+
+func NewStore() *Store {
+	return &Store{subs: map[*subscriber]struct{}{}}
+}
+
+// Track returns the current track as stored, without the idle screen,
+// masking or art details the overlay gets
+func (s *Store) Track() NowPlaying {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.track
+}
+
+func (s *Store) Art() Art {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.art
+}
+
+func (s *Store) LastUpdate() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastUpdate
+}
+
+func (s *Store) isStopped() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.stopped
+}
+
+// Subscribe returns a channel of updates and a function to stop them.
+// Updates a slow subscriber hasn't taken yet are merged into the latest
+// one, which keeps TrackChanged if any of them had it, so the store is
+// never held up and a new track is never missed.
+func (s *Store) Subscribe() (<-chan Update, func()) {
+	sub := &subscriber{wake: make(chan struct{}, 1), done: make(chan struct{})}
+	out := make(chan Update)
+	s.subsMu.Lock()
+	s.subs[sub] = struct{}{}
+	s.subsMu.Unlock()
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-sub.wake:
+			case <-sub.done:
+				return
+			}
+			u, ok := sub.take()
+			if !ok {
+				continue
+			}
+			select {
+			case out <- u:
+			case <-sub.done:
+				return
+			}
+		}
+	}()
+	return out, func() {
+		s.subsMu.Lock()
+		if _, ok := s.subs[sub]; ok {
+			delete(s.subs, sub)
+			close(sub.done)
+		}
+		s.subsMu.Unlock()
+	}
+}
+
+func (s *Store) notify(u Update) {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	for sub := range s.subs {
+		sub.offer(u)
+	}
+}
+
+// subscriber holds the one update waiting for a subscriber
+type subscriber struct {
+	mu      sync.Mutex
+	pending Update
+	has     bool
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+func (sub *subscriber) offer(u Update) {
+	sub.mu.Lock()
+	if sub.has {
+		u.TrackChanged = u.TrackChanged || sub.pending.TrackChanged
+	}
+	sub.pending, sub.has = u, true
+	sub.mu.Unlock()
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (sub *subscriber) take() (Update, bool) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	u, ok := sub.pending, sub.has
+	sub.pending, sub.has = Update{}, false
+	return u, ok
+}
+
+// touchAt records that the source was heard from at t. It never moves
+// back in time or past now, whatever the source's clock says.
+func (s *Store) touchAt(t time.Time) {
+	if now := time.Now(); t.After(now) {
+		t = now
+	}
+	s.mu.Lock()
+	if t.After(s.lastUpdate) {
+		s.lastUpdate = t
+	}
+	s.mu.Unlock()
+}
+
+func (s *Store) setLastIncoming(t NowPlaying) {
+	s.mu.Lock()
+	s.lastIncoming = t
+	s.mu.Unlock()
+}
+
+func (s *Store) LastIncoming() NowPlaying {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastIncoming
+}
+
+// setTrack replaces the current track without touching the art
+func (s *Store) setTrack(t NowPlaying) {
+	s.mu.Lock()
+	changed := trackKey(t.Artist, t.SongName) != trackKey(s.track.Artist, s.track.SongName)
+	s.track, s.stopped = t, false
+	version := s.art.Version
+	s.mu.Unlock()
+	s.notify(Update{Track: t, TrackChanged: changed, ArtVersion: version})
+}
+
+// stop marks the current track as over, so the overlay goes idle now
+// rather than once the source is stale
+func (s *Store) stop() {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.stopped = true
+	u := Update{Track: s.track, ArtVersion: s.art.Version}
+	s.mu.Unlock()
+	s.notify(u)
+}
+
+// publish makes t current and reports whether it is a different song and
+// whether artSource needs fetching. The art source is compared against
+// the last one requested rather than the cached one, so a failing URL
+// isn't refetched on every post.
+func (s *Store) publish(t NowPlaying, artSource string) (changed, fetchArt bool) {
+	s.mu.Lock()
+	urlChanged := artSource != "" && artSource != s.requestedArtURL
+	if urlChanged {
+		s.requestedArtURL = artSource
+	}
+	changed = trackKey(t.Artist, t.SongName) != trackKey(s.track.Artist, s.track.SongName)
+	// Tracks without art get a fallback lookup once, when they start
+	needsFallback := artSource == "" && changed
+	s.track, s.stopped = t, false
+	version := s.art.Version
+	s.mu.Unlock()
+	s.notify(Update{Track: t, TrackChanged: changed, ArtVersion: version})
+	return changed, urlChanged || needsFallback
+}
+
+// setArt replaces the cached art and bumps its version. requested is the
+// art source publish asked for when the update started. When that is no
+// longer the last one asked for, as with fallback art for a track without
+// a URL, the cache no longer holds the requested art and the next track
+// with that source has to fetch it again.
+func (s *Store) setArt(requested, src, forTrack string, data []byte, contentType string) {
+	// Palette is computed once per art version, not per overlay
+	palette, err := extractPaletteFromBytes(data)
+	if err != nil {
+		palette = nil
+	}
+	s.mu.Lock()
+	if requested != s.requestedArtURL {
+		s.requestedArtURL = ""
+	}
+	s.art = Art{
+		URL:         src,
+		Track:       forTrack,
+		Data:        data,
+		ContentType: contentType,
+		Version:     s.art.Version + 1,
+		Palette:     palette,
+	}
+	u := Update{Track: s.track, ArtVersion: s.art.Version}
+	s.mu.Unlock()
+	s.notify(u)
+}
+
+// stateSnapshot is what survives a restart. The palette isn't stored
+// since it is cheap to recompute from the art.
+type stateSnapshot struct {
+	Saved             time.Time  `json:"saved"`
+	Track             NowPlaying `json:"track"`
+	LastIncomingTrack NowPlaying `json:"last_incoming_track"`
+	LastUpdate        time.Time  `json:"last_update"`
+	RequestedArtURL   string     `json:"requested_art_url,omitempty"`
+	ArtURL            string     `json:"art_url,omitempty"`
+	ArtTrack          string     `json:"art_track,omitempty"`
+	ArtContentType    string     `json:"art_content_type,omitempty"`
+	ArtVersion        int        `json:"art_version"`
+	Art               []byte     `json:"art,omitempty"`
+}
+
+// SaveFile writes the track and art to path, for LoadFile on the next
+// start
+func (s *Store) SaveFile(path string) error {
+	s.mu.RLock()
+	snap := stateSnapshot{
+		Saved:             time.Now(),
+		Track:             s.track,
+		LastIncomingTrack: s.lastIncoming,
+		LastUpdate:        s.lastUpdate,
+		RequestedArtURL:   s.requestedArtURL,
+		ArtURL:            s.art.URL,
+		ArtTrack:          s.art.Track,
+		ArtContentType:    s.art.ContentType,
+		ArtVersion:        s.art.Version,
+		Art:               s.art.Data,
+	}
+	s.mu.RUnlock()
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadFile restores a snapshot written by SaveFile. A missing file is not
+// an error. The source is still considered live as of the snapshot's
+// last update, so a quick restart keeps the track up while a long one
+// goes straight to the idle screen.
+func (s *Store) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap stateSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+
+	var palette *Palette
+	if len(snap.Art) > 0 {
+		palette, _ = extractPaletteFromBytes(snap.Art)
+	}
+	s.mu.Lock()
+	s.track = snap.Track
+	s.lastIncoming = snap.LastIncomingTrack
+	s.lastUpdate = snap.LastUpdate
+	s.requestedArtURL = snap.RequestedArtURL
+	s.art = Art{
+		URL:         snap.ArtURL,
+		Track:       snap.ArtTrack,
+		Data:        snap.Art,
+		ContentType: snap.ArtContentType,
+		Version:     snap.ArtVersion,
+		Palette:     palette,
+	}
+	s.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,91 @@
+package nowplaying
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStoreArtRequests(t *testing.T) {
+	a := NowPlaying{SongName: "A", Artist: "Artist"}
+	b := NowPlaying{SongName: "B", Artist: "Artist"}
+	const x = "https://example.com/a.jpg"
+	const fallback = "https://coverartarchive.org/b.jpg"
+
+	steps := []struct {
+		name      string
+		track     NowPlaying
+		source    string
+		wantFetch bool
+		// art stored by the update that step starts, if any
+		artFrom, artSrc string
+	}{
+		{name: "A with its URL", track: a, source: x, wantFetch: true, artFrom: x, artSrc: x},
+		{name: "A posted again", track: a, source: x, wantFetch: false},
+		{name: "B without a URL", track: b, source: "", wantFetch: true, artFrom: "", artSrc: fallback},
+		{name: "B posted again", track: b, source: "", wantFetch: false},
+		{name: "A again after B's fallback art", track: a, source: x, wantFetch: true, artFrom: x, artSrc: x},
+		{name: "A posted once more", track: a, source: x, wantFetch: false},
+	}
+	s := NewStore()
+	for _, st := range steps {
+		_, fetch := s.publish(st.track, st.source)
+		if fetch != st.wantFetch {
+			t.Fatalf("%s: fetch = %v, want %v", st.name, fetch, st.wantFetch)
+		}
+		if st.artSrc != "" {
+			s.setArt(st.artFrom, st.artSrc, trackKey(st.track.Artist, st.track.SongName), []byte("art"), "image/jpeg")
+		}
+	}
+}
+
+func TestStoreFallbackForFailedURL(t *testing.T) {
+	// A's own URL failed and the fallback filled in; later posts of A with
+	// the same URL mustn't fetch again
+	a := NowPlaying{SongName: "A", Artist: "Artist"}
+	const x = "https://example.com/broken.jpg"
+	s := NewStore()
+	if _, fetch := s.publish(a, x); !fetch {
+		t.Fatal("first post: want a fetch")
+	}
+	s.setArt(x, "https://coverartarchive.org/a.jpg", trackKey(a.Artist, a.SongName), []byte("art"), "image/jpeg")
+	if _, fetch := s.publish(a, x); fetch {
+		t.Error("second post: want no fetch")
+	}
+}
+
+func TestSubscribeSlowSubscriber(t *testing.T) {
+	s := NewStore()
+	updates, stop := s.Subscribe()
+	defer stop()
+
+	// Far more updates than a buffer would hold, with the song changing
+	// in the middle, while nobody reads
+	for i := 0; i < 50; i++ {
+		s.publish(NowPlaying{SongName: "A", Artist: "Artist", CurrentSeconds: i}, "")
+	}
+	s.publish(NowPlaying{SongName: "B", Artist: "Artist"}, "")
+	for i := 1; i < 50; i++ {
+		s.publish(NowPlaying{SongName: "B", Artist: "Artist", CurrentSeconds: i}, "")
+	}
+
+	var got []Update
+	for len(got) == 0 || got[len(got)-1].Track.CurrentSeconds != 49 || got[len(got)-1].Track.SongName != "B" {
+		select {
+		case u := <-updates:
+			got = append(got, u)
+		case <-time.After(time.Second):
+			t.Fatalf("no latest update, got %+v", got)
+		}
+	}
+	if len(got) > 2 {
+		t.Errorf("got %d updates, want them merged into at most 2", len(got))
+	}
+	if last := got[len(got)-1]; !last.TrackChanged {
+		t.Errorf("latest update %+v lost TrackChanged", last)
+	}
+
+	stop()
+	if _, ok := <-updates; ok {
+		t.Error("channel still open after stop")
+	}
+}
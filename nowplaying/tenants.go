@@ -0,0 +1,65 @@
+package nowplaying
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// TenantPrefix is where each tenant's overlay and APIs live, as
+// /s/{name}/
+const TenantPrefix = "/s/"
+
+var tenantNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// Tenants serves several streamers from one process, each with its own
+// Handler and Store under /s/{name}/. Tenants share nothing but the
+// process and whatever the caller passes to more than one Handler, such
+// as an ArtLimiter.
+type Tenants struct {
+	mu       sync.RWMutex
+	handlers map[string]*Handler
+}
+
+func NewTenants() *Tenants {
+	return &Tenants{handlers: map[string]*Handler{}}
+}
+
+// Add serves h as tenant name. Names are lowercase letters, digits, dashes
+// and underscores.
+func (t *Tenants) Add(name string, h *Handler) error {
+	if !tenantNameRe.MatchString(name) {
+		return fmt.Errorf("invalid tenant name %q (use a-z, 0-9, - and _)", name)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.handlers[name]; ok {
+		return fmt.Errorf("duplicate tenant %q", name)
+	}
+	t.handlers[name] = h
+	return nil
+}
+
+func (t *Tenants) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest, ok := strings.CutPrefix(r.URL.Path, TenantPrefix)
+	name, _, found := strings.Cut(rest, "/")
+	t.mu.RLock()
+	h := t.handlers[name]
+	t.mu.RUnlock()
+	if !ok || h == nil {
+		http.NotFound(w, r)
+		return
+	}
+	// The pages use relative URLs, which need the trailing slash
+	if !found {
+		target := TenantPrefix + name + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+	http.StripPrefix(TenantPrefix+name, h).ServeHTTP(w, r)
+}
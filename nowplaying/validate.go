@@ -0,0 +1,195 @@
+package nowplaying
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBodySize caps request bodies; a real track post is well under 1 KB.
+// Batches of up to 500 updates get more room.
+const (
+	maxBodySize      = 64 << 10
+	maxBatchBodySize = 512 << 10
+)
+
+// trackUpdate is a posted track, the TrackUpdate schema in openapi.json.
+// The seconds are pointers so a missing value can fall back to the
+// timestamp while 0 still means the start of the song.
+type trackUpdate struct {
+	SongName         string  `json:"song_name"`
+	Artist           string  `json:"artist"`
+	CurrentTimestamp string  `json:"current_timestamp"`
+	EndTimestamp     string  `json:"end_timestamp"`
+	AlbumArtURL      *string `json:"album_art_url"`
+	VideoID          string  `json:"video_id"`
+	CurrentSeconds   *int    `json:"current_seconds"`
+	EndSeconds       *int    `json:"end_seconds"`
+	ProgressPct      float64 `json:"progress_pct"`
+	// Source names the player or tab sending the update
+	Source string `json:"source"`
+	// At is when the client saw this state, if it says
+	At *time.Time `json:"at"`
+}
+
+// readBody reads a request body up to limit bytes
+func readBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, *apiError) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, newAPIError(http.StatusRequestEntityTooLarge, "body_too_large",
+			"Request body is larger than "+strconv.FormatInt(limit>>10, 10)+" KB")
+	}
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, "invalid_body", "Invalid request body")
+	}
+	return body, nil
+}
+
+// requireJSON rejects bodies that aren't sent as application/json
+func requireJSON(r *http.Request) *apiError {
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/json" {
+		return newAPIError(http.StatusUnsupportedMediaType, "unsupported_media_type", "Content-Type must be application/json")
+	}
+	return nil
+}
+
+// decodeJSON reads a JSON body, checks it against the named schema from
+// openapi.json and decodes it into v. An empty body is only allowed when
+// allowEmpty is set, and leaves v untouched.
+func decodeJSON(w http.ResponseWriter, r *http.Request, schemaName string, v any, allowEmpty bool) *apiError {
+	limit := int64(maxBodySize)
+	if schemaName == "TrackBatch" {
+		limit = maxBatchBodySize
+	}
+	body, err := readBody(w, r, limit)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if allowEmpty {
+			return nil
+		}
+		return newAPIError(http.StatusBadRequest, "invalid_json", "Request body is empty")
+	}
+	if err := requireJSON(r); err != nil {
+		return err
+	}
+	return decodeBody(body, schemaName, v, true)
+}
+
+// decodeBody checks body against the named schema and decodes it into v.
+// Without strict, fields the schema doesn't know are dropped instead of
+// rejected.
+func decodeBody(body []byte, schemaName string, v any, strict bool) *apiError {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var raw any
+	if err := dec.Decode(&raw); err != nil {
+		return newAPIError(http.StatusBadRequest, "invalid_json", "Invalid JSON: "+err.Error())
+	}
+	errs := validateSchema(schemaName, raw)
+	if !strict {
+		kept := errs[:0]
+		for _, e := range errs {
+			if e.Message != msgUnknownField {
+				kept = append(kept, e)
+			}
+		}
+		errs = kept
+	}
+	if len(errs) > 0 {
+		return invalidFields(errs)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return newAPIError(http.StatusBadRequest, "invalid_json", "Invalid JSON: "+err.Error())
+	}
+	return nil
+}
+
+func invalidFields(errs []fieldError) *apiError {
+	e := newAPIError(http.StatusUnprocessableEntity, "invalid_request", "Request has invalid fields")
+	e.Fields = errs
+	return e
+}
+
+// normalize checks what the schema can't express. The seconds win over
+// the timestamps, which are rewritten from them, so "1:02:03", "62:03"
+// and 3723 all end up the same.
+func (u trackUpdate) normalize() (NowPlaying, []fieldError) {
+	var errs []fieldError
+	seconds := func(n *int, ts, field string) int {
+		s := 0
+		if strings.TrimSpace(ts) != "" {
+			var ok bool
+			if s, ok = parseTimestamp(ts); !ok {
+				errs = append(errs, fieldError{Field: field, Message: "must look like m:ss or h:mm:ss"})
+			}
+		}
+		if n != nil {
+			return *n
+		}
+		return s
+	}
+	t := NowPlaying{
+		SongName:       u.SongName,
+		Artist:         u.Artist,
+		VideoID:        u.VideoID,
+		CurrentSeconds: seconds(u.CurrentSeconds, u.CurrentTimestamp, "current_timestamp"),
+		EndSeconds:     seconds(u.EndSeconds, u.EndTimestamp, "end_timestamp"),
+	}
+	if t.CurrentSeconds < 0 {
+		errs = append(errs, fieldError{Field: "current_seconds", Message: "must not be negative"})
+	}
+	if t.EndSeconds < 0 {
+		errs = append(errs, fieldError{Field: "end_seconds", Message: "must not be negative"})
+	}
+	if t.EndSeconds > 0 && t.CurrentSeconds > t.EndSeconds {
+		errs = append(errs, fieldError{Field: "current_seconds", Message: "is past the end of the track"})
+	}
+	if u.AlbumArtURL != nil && *u.AlbumArtURL != "" {
+		t.AlbumArtURL = strings.TrimSpace(*u.AlbumArtURL)
+		if p, err := url.Parse(t.AlbumArtURL); err != nil || (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+			errs = append(errs, fieldError{Field: "album_art_url", Message: "must be an http or https URL"})
+		}
+	}
+
+	t.CurrentTimestamp = formatSeconds(max(t.CurrentSeconds, 0))
+	t.EndTimestamp = formatSeconds(max(t.EndSeconds, 0))
+	return t, errs
+}
+
+// parseTimestamp reads m:ss, mm:ss or h:mm:ss. Minutes may run past 59
+// when there is no hour part, as some players show long mixes that way.
+func parseTimestamp(s string) (int, bool) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+	nums := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || (i > 0 && len(p) != 2) {
+			return 0, false
+		}
+		nums[i] = n
+	}
+	secs := nums[len(nums)-1]
+	if secs > 59 {
+		return 0, false
+	}
+	if len(nums) == 3 {
+		if nums[1] > 59 {
+			return 0, false
+		}
+		return nums[0]*3600 + nums[1]*60 + secs, true
+	}
+	return nums[0]*60 + secs, true
+}
@@ -0,0 +1,100 @@
+package nowplaying
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+		ok   bool
+	}{
+		{"0:00", 0, true},
+		{"3:07", 187, true},
+		{"03:07", 187, true},
+		{" 12:34 ", 754, true},
+		{"95:00", 5700, true},
+		{"1:02:03", 3723, true},
+		{"10:00:00", 36000, true},
+		{"", 0, false},
+		{"42", 0, false},
+		{"3:7", 0, false},
+		{"3:60", 0, false},
+		{"1:60:00", 0, false},
+		{"1:2:03", 0, false},
+		{"1:02:03:04", 0, false},
+		{"-1:00", 0, false},
+		{"a:bc", 0, false},
+		{"1:0x", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseTimestamp(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseTimestamp(%q) = %d, %v, want %d, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	ptr := func(s string) *string { return &s }
+	num := func(n int) *int { return &n }
+	tests := []struct {
+		name   string
+		u      trackUpdate
+		want   NowPlaying
+		fields []string
+	}{
+		{
+			name: "timestamps",
+			u:    trackUpdate{SongName: "Song", Artist: "Artist", CurrentTimestamp: "1:05", EndTimestamp: "1:02:00"},
+			want: NowPlaying{SongName: "Song", Artist: "Artist", CurrentSeconds: 65, EndSeconds: 3720, CurrentTimestamp: "1:05", EndTimestamp: "1:02:00"},
+		},
+		{
+			name: "seconds win over timestamps",
+			u:    trackUpdate{SongName: "Song", CurrentSeconds: num(10), CurrentTimestamp: "0:05", EndSeconds: num(200)},
+			want: NowPlaying{SongName: "Song", CurrentSeconds: 10, EndSeconds: 200, CurrentTimestamp: "0:10", EndTimestamp: "3:20"},
+		},
+		{
+			name: "art URL is trimmed",
+			u:    trackUpdate{SongName: "Song", AlbumArtURL: ptr(" https://lh3.googleusercontent.com/a=s512 ")},
+			want: NowPlaying{SongName: "Song", AlbumArtURL: "https://lh3.googleusercontent.com/a=s512", CurrentTimestamp: "0:00", EndTimestamp: "0:00"},
+		},
+		{
+			name:   "bad timestamp",
+			u:      trackUpdate{SongName: "Song", CurrentTimestamp: "soon"},
+			fields: []string{"current_timestamp"},
+		},
+		{
+			name:   "negative length",
+			u:      trackUpdate{CurrentSeconds: num(300), EndSeconds: num(-1)},
+			fields: []string{"end_seconds"},
+		},
+		{
+			name:   "position past the end",
+			u:      trackUpdate{CurrentSeconds: num(300), EndSeconds: num(200)},
+			fields: []string{"current_seconds"},
+		},
+		{
+			name:   "art URL that isn't http",
+			u:      trackUpdate{AlbumArtURL: ptr("file:///etc/passwd")},
+			fields: []string{"album_art_url"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, errs := tt.u.normalize()
+			var fields []string
+			for _, e := range errs {
+				fields = append(fields, e.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Fatalf("errors on %q, want %q", fields, tt.fields)
+			}
+			if tt.fields == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
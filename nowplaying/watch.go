@@ -0,0 +1,226 @@
+package nowplaying
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+const (
+	defaultWatchInterval = time.Second
+	// The track is sent again this often while the file has one, so the
+	// overlay doesn't go idle between songs. The file is also reread this
+	// often, for players whose rewrites keep its time and size.
+	watchKeepalive = 5 * time.Second
+)
+
+// FileWatchSource follows a now-playing text file that a player rewrites
+// or appends to, such as foobar2000's or a VLC script's "Artist - Title".
+// Pattern is tried on the whole file, then on its last line. An empty or
+// missing file means nothing is playing, and the overlay goes idle as
+// soon as the file empties.
+type FileWatchSource struct {
+	Path string
+	// Pattern comes from CompileTrackPattern
+	Pattern *regexp.Regexp
+	// CoverPath is an optional image the player keeps next to the file;
+	// it is shown as the album art and reloaded whenever it changes
+	CoverPath string
+	// SourceID defaults to the file's name
+	SourceID string
+	// Interval is how often the files are checked, a second by default
+	Interval time.Duration
+}
+
+var templateFieldRe = regexp.MustCompile(`\{(\w+)\}`)
+
+// CompileTrackPattern compiles a pattern for FileWatchSource. A pattern
+// with named groups, like (?P<artist>.+) - (?P<title>.+), is a regular
+// expression; anything else is a template such as "{artist} - {title}".
+// Names are TrackUpdate keys or title, art_url, position and length.
+func CompileTrackPattern(pattern string) (*regexp.Regexp, error) {
+	expr := pattern
+	if !strings.Contains(pattern, "(?P<") && !strings.Contains(pattern, "(?<") {
+		var b strings.Builder
+		last := 0
+		for _, m := range templateFieldRe.FindAllStringSubmatchIndex(pattern, -1) {
+			b.WriteString(regexp.QuoteMeta(pattern[last:m[0]]))
+			b.WriteString("(?P<" + pattern[m[2]:m[3]] + ">.*?)")
+			last = m[1]
+		}
+		b.WriteString(regexp.QuoteMeta(pattern[last:]))
+		expr = `^\s*` + b.String() + `\s*$`
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	named := 0
+	for _, name := range re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		named++
+		key := name
+		if alias, ok := lineKeyAliases[name]; ok {
+			key = alias
+		}
+		if _, ok := apiSchemas["TrackUpdate"].Properties[key]; !ok {
+			return nil, fmt.Errorf("pattern %q: unknown field %q", pattern, name)
+		}
+	}
+	if named == 0 {
+		return nil, fmt.Errorf("pattern %q has no fields", pattern)
+	}
+	return re, nil
+}
+
+func (s *FileWatchSource) Run(ctx context.Context, emit func(NowPlaying)) error {
+	if s.Pattern == nil {
+		return fmt.Errorf("watch %s: no pattern", s.Path)
+	}
+	id := s.SourceID
+	if id == "" {
+		id = filepath.Base(s.Path)
+	}
+	interval := s.Interval
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var (
+		textStamp, coverStamp string
+		textSum               [sha256.Size]byte
+		lastRead              time.Time
+		track                 NowPlaying
+		cover                 *artLoader
+		lastEmit              time.Time
+		lastErr               string
+		playing               bool
+	)
+	for {
+		changed := false
+		// The stamp catches most rewrites; the checksum catches the rest
+		if stamp := fileStamp(s.Path); stamp != textStamp || time.Since(lastRead) >= watchKeepalive {
+			data, err := readWatchedFile(s.Path)
+			lastRead = time.Now()
+			if sum := sha256.Sum256(data); stamp != textStamp || sum != textSum {
+				textStamp, textSum, changed = stamp, sum, true
+				var t NowPlaying
+				if err == nil {
+					t, err = s.parseTrack(data)
+				}
+				if err != nil && err.Error() != lastErr {
+					slog.Warn("could not read now-playing file", "path", s.Path, "err", err)
+				}
+				lastErr = ""
+				if err != nil {
+					lastErr = err.Error()
+				}
+				track = t
+				// Only an empty file says the player stopped; a file that
+				// can't be read or parsed lets the track go stale instead
+				if err == nil && t.SongName == "" && playing {
+					emit(NowPlaying{Source: id})
+					playing = false
+				}
+			}
+		}
+		if s.CoverPath != "" {
+			if stamp := fileStamp(s.CoverPath); stamp != coverStamp {
+				coverStamp, changed = stamp, true
+				cover = nil
+				if stamp != "" {
+					// The stamp in the source makes a rewritten image count
+					// as new art
+					cover = fileArtLoader(s.CoverPath)
+					cover.source += "?v=" + stamp
+				}
+			}
+		}
+
+		if track.SongName != "" && (changed || time.Since(lastEmit) >= watchKeepalive) {
+			t := track
+			t.Source, t.cover = id, cover
+			emit(t)
+			lastEmit, playing = time.Now(), true
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// fileStamp changes whenever a file is rewritten; it is empty when the
+// file is missing
+func fileStamp(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return strconv.FormatInt(info.ModTime().UnixNano(), 10) + "-" + strconv.FormatInt(info.Size(), 10)
+}
+
+// readWatchedFile reads the file; a missing file reads as empty
+func readWatchedFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// parseTrack parses the file's contents; an empty file is nothing playing
+func (s *FileWatchSource) parseTrack(data []byte) (NowPlaying, error) {
+	text := strings.TrimSpace(decodeText(data))
+	if text == "" {
+		return NowPlaying{}, nil
+	}
+	m := s.Pattern.FindStringSubmatch(text)
+	if m == nil {
+		lines := strings.Split(text, "\n")
+		m = s.Pattern.FindStringSubmatch(strings.TrimSpace(lines[len(lines)-1]))
+	}
+	if m == nil {
+		return NowPlaying{}, fmt.Errorf("%q doesn't match the pattern", text)
+	}
+	fields := map[string]string{}
+	for i, name := range s.Pattern.SubexpNames() {
+		if name != "" && m[i] != "" {
+			fields[name] = strings.TrimSpace(m[i])
+		}
+	}
+	return trackFromFields(fields)
+}
+
+// decodeText reads text the way Windows players write it: UTF-8 or
+// UTF-16 with a byte order mark, plain UTF-8, or else Windows-1252
+func decodeText(data []byte) string {
+	if out, _, err := transform.Bytes(unicode.BOMOverride(transform.Nop), data); err == nil {
+		data = out
+	}
+	if !utf8.Valid(data) {
+		if out, err := charmap.Windows1252.NewDecoder().Bytes(data); err == nil {
+			data = out
+		}
+	}
+	return string(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")))
+}
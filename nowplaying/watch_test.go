@@ -0,0 +1,170 @@
+package nowplaying
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompileTrackPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		want    map[string]string
+		err     bool
+	}{
+		{
+			pattern: "{artist} - {title}",
+			text:    "  Daft Punk - One More Time ",
+			want:    map[string]string{"artist": "Daft Punk", "title": "One More Time"},
+		},
+		{
+			pattern: "{artist} - {title}",
+			text:    "Jay-Z - 99 Problems",
+			want:    map[string]string{"artist": "Jay-Z", "title": "99 Problems"},
+		},
+		{
+			pattern: "Now playing: {title} by {artist} [{position}/{length}]",
+			text:    "Now playing: Song (Remix) by Artist [1:02/3:30]",
+			want:    map[string]string{"title": "Song (Remix)", "artist": "Artist", "position": "1:02", "length": "3:30"},
+		},
+		{
+			pattern: "{title} ({artist})",
+			text:    "Song (Artist)",
+			want:    map[string]string{"title": "Song", "artist": "Artist"},
+		},
+		{
+			pattern: `^(?P<artist>.+?) \| (?P<song_name>.+)$`,
+			text:    "A | B | C",
+			want:    map[string]string{"artist": "A", "song_name": "B | C"},
+		},
+		{
+			pattern: "{artist} - {title}",
+			text:    "no separator here",
+			want:    nil,
+		},
+		{pattern: "just text", err: true},
+		{pattern: "{artist} - {bitrate}", err: true},
+		{pattern: `(?P<album>.+)`, err: true},
+		{pattern: `(?P<title>(.+)`, err: true},
+	}
+	for _, tt := range tests {
+		re, err := CompileTrackPattern(tt.pattern)
+		if tt.err {
+			if err == nil {
+				t.Errorf("CompileTrackPattern(%q): want an error", tt.pattern)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("CompileTrackPattern(%q): %v", tt.pattern, err)
+			continue
+		}
+		m := re.FindStringSubmatch(tt.text)
+		var got map[string]string
+		if m != nil {
+			got = map[string]string{}
+			for i, name := range re.SubexpNames() {
+				if name != "" {
+					got[name] = m[i]
+				}
+			}
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%q on %q = %q, want %q", tt.pattern, tt.text, got, tt.want)
+			continue
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Errorf("%q on %q: %s = %q, want %q", tt.pattern, tt.text, k, got[k], v)
+			}
+		}
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		want string
+	}{
+		{"utf-8", []byte("Beyoncé - Halo"), "Beyoncé - Halo"},
+		{"utf-8 with bom", []byte("\xef\xbb\xbfBeyoncé"), "Beyoncé"},
+		{"utf-16le with bom", []byte("\xff\xfeB\x00\xe9\x00\r\x00\n\x00x\x00"), "Bé\nx"},
+		{"utf-16be with bom", []byte("\xfe\xff\x00B\x00\xe9"), "Bé"},
+		{"windows-1252", []byte("Beyonc\xe9 \x96 Halo"), "Beyoncé – Halo"},
+		{"crlf", []byte("a\r\nb\r\n"), "a\nb\n"},
+	}
+	for _, tt := range tests {
+		if got := decodeText(tt.in); got != tt.want {
+			t.Errorf("%s: decodeText = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFileWatchSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "np.txt")
+	write := func(text string, mtime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	write("Artist - Song A", mtime)
+
+	pattern, err := CompileTrackPattern("{artist} - {title}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := &FileWatchSource{Path: path, Pattern: pattern, Interval: 10 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	emitted := make(chan NowPlaying, 16)
+	go src.Run(ctx, func(t NowPlaying) { emitted <- t })
+
+	next := func(within time.Duration) NowPlaying {
+		t.Helper()
+		select {
+		case np := <-emitted:
+			return np
+		case <-time.After(within):
+			t.Fatal("nothing emitted")
+			return NowPlaying{}
+		}
+	}
+	if got := next(time.Second); got.SongName != "Song A" || got.Source != "np.txt" {
+		t.Fatalf("first emit = %+v", got)
+	}
+
+	// Emptying the file stops the source
+	write("", mtime.Add(time.Second))
+	if got := next(time.Second); got.SongName != "" || got.Artist != "" || got.Source != "np.txt" {
+		t.Fatalf("emit after emptying = %+v, want a stop", got)
+	}
+
+	write("Artist - Song B", mtime.Add(2*time.Second))
+	if got := next(time.Second); got.SongName != "Song B" {
+		t.Fatalf("emit after refilling = %+v", got)
+	}
+	if testing.Short() {
+		return
+	}
+
+	// Same size and time, so only the checksum on the keepalive sees it
+	write("Artist - Song C", mtime.Add(2*time.Second))
+	for {
+		got := next(2 * watchKeepalive)
+		if got.SongName == "Song C" {
+			break
+		}
+		if got.SongName != "Song B" {
+			t.Fatalf("emit after same-stamp rewrite = %+v", got)
+		}
+	}
+}
@@ -4,11 +4,11 @@ try { console.log('PiffMusic background loaded'); } catch (_) {}
 (typeof browser !== 'undefined' ? browser : chrome).runtime.onMessage.addListener((msg) => {
   if (!msg || msg.type !== 'postNowPlaying' || !msg.payload) return;
   const payload = msg.payload;
-  return fetch('http://localhost:17890/webhook', {
+  return loadSettings().then((settings) => fetch(stateUrl(settings), {
     method: 'POST',
-    headers: { 'Content-Type': 'application/json' },
+    headers: requestHeaders(settings),
     body: JSON.stringify(payload),
-  }).then(() => ({ ok: true })).catch((err) => ({ ok: false, error: String(err) }));
+  })).then(() => ({ ok: true })).catch((err) => ({ ok: false, error: String(err) }));
 });
 
 
@@ -62,7 +62,8 @@ function getNowPlaying() {
 
     const { startTime, endTime, currentSeconds, endSeconds } = getTimes();
 
-    const albumArtUrl = capAlbumArtResolution(upgradeAlbumArtResolution(getAlbumArtUrl()));
+    // The server picks the art size, so send the URL untouched
+    const albumArtUrl = getAlbumArtUrl();
 
     return {
         song_name: title,
@@ -70,33 +71,33 @@ function getNowPlaying() {
         current_timestamp: startTime,
         end_timestamp: endTime,
         album_art_url: albumArtUrl,
+        video_id: getVideoId(),
         current_seconds: currentSeconds,
         end_seconds: endSeconds,
         progress_pct: computeProgressPct(currentSeconds, endSeconds)
     };
 }
 
-function upgradeAlbumArtResolution(url) {
-    if (!url || typeof url !== 'string') return url;
+function getVideoId() {
     try {
-        const u = new URL(url, location.href);
-        if (u.hostname.includes('googleusercontent.com')) {
-            return url.replace(/w\d+-h\d+/g, 'w1600-h1600');
-        }
+        return new URLSearchParams(location.search).get('v') || '';
     } catch (e) {
+        return '';
     }
-    return url;
 }
 
-function capAlbumArtResolution(url) {
-    if (!url || typeof url !== 'string') return url;
-    try {
-        const u = new URL(url, location.href);
-        if (u.hostname.includes('googleusercontent.com')) {
-            return url.replace(/w\d+-h\d+/g, 'w800-h800');
-        }
-    } catch (e) { }
-    return url;
+// Each tab is its own source, so two tabs playing at once don't fight
+// over the overlay. The "ytmusic" prefix lets -source-priority match them.
+const SOURCE_ID = 'ytmusic-' + Math.random().toString(36).slice(2, 8);
+
+// lastSent is the last payload the server took; while only the position
+// moves, just the position is sent as a PATCH
+let lastSent = null;
+const POSITION_FIELDS = ['current_timestamp', 'current_seconds', 'progress_pct'];
+
+function onlyPositionChanged(prev, next) {
+    if (!prev) return false;
+    return Object.keys(next).every(k => POSITION_FIELDS.includes(k) || prev[k] === next[k]);
 }
 
 function postNowPlaying() {
@@ -108,14 +109,26 @@ function postNowPlaying() {
             current_timestamp: nowPlaying.current_timestamp,
             end_timestamp: nowPlaying.end_timestamp,
             album_art_url: nowPlaying.album_art_url,
+            video_id: nowPlaying.video_id,
             current_seconds: nowPlaying.current_seconds,
-            end_seconds: nowPlaying.end_seconds
+            end_seconds: nowPlaying.end_seconds,
+            progress_pct: nowPlaying.progress_pct,
+            source: SOURCE_ID
         };
-        fetch("http://localhost:17890/webhook", {
-            method: "POST",
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify(payload)
+        const patch = onlyPositionChanged(lastSent, payload);
+        const body = patch
+            ? { current_timestamp: payload.current_timestamp, current_seconds: payload.current_seconds, progress_pct: payload.progress_pct, source: SOURCE_ID }
+            : payload;
+        fetch(stateUrl(settings), {
+            method: patch ? "PATCH" : "POST",
+            headers: requestHeaders(settings),
+            body: JSON.stringify(body)
+        }).then((resp) => {
+            // Anything but success (e.g. 409 after a server restart) sends
+            // the full payload next time
+            lastSent = resp.ok ? payload : null;
         }).catch(() => {
+            lastSent = null;
             try {
                 (typeof browser !== 'undefined' ? browser : chrome).runtime.sendMessage({ type: 'postNowPlaying', payload });
             } catch (_) {}
@@ -124,6 +137,9 @@ function postNowPlaying() {
     }
 }
 
+let settings = DEFAULT_SETTINGS;
+loadSettings().then(s => { settings = s; });
+
 setTimeout(() => {
     postNowPlaying();
     setInterval(postNowPlaying, 1000);
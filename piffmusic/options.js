@@ -0,0 +1,17 @@
+const serverInput = document.getElementById('server');
+const tokenInput = document.getElementById('token');
+
+loadSettings().then(s => {
+    serverInput.value = s.server;
+    tokenInput.value = s.token;
+});
+
+document.getElementById('save').addEventListener('click', () => {
+    const api = typeof browser !== 'undefined' ? browser : chrome;
+    api.storage.local.set({
+        server: serverInput.value.trim() || DEFAULT_SETTINGS.server,
+        token: tokenInput.value.trim()
+    }).then(() => {
+        document.getElementById('status').textContent = 'Saved. Reload the YouTube Music tab.';
+    });
+});
@@ -0,0 +1,22 @@
+// Where to post tracks; shared by the content script, the background
+// script and the options page
+const DEFAULT_SETTINGS = { server: 'http://localhost:17890', token: '' };
+
+function loadSettings() {
+    const api = typeof browser !== 'undefined' ? browser : chrome;
+    try {
+        return api.storage.local.get(DEFAULT_SETTINGS).then(s => Object.assign({}, DEFAULT_SETTINGS, s));
+    } catch (_) {
+        return Promise.resolve(DEFAULT_SETTINGS);
+    }
+}
+
+function stateUrl(settings, path) {
+    return settings.server.replace(/\/+$/, '') + '/api/v1/state' + (path || '');
+}
+
+function requestHeaders(settings) {
+    const headers = { 'Content-Type': 'application/json' };
+    if (settings.token) headers['Authorization'] = 'Bearer ' + settings.token;
+    return headers;
+}
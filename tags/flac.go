@@ -0,0 +1,135 @@
+package tags
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+const (
+	flacVorbisComment = 4
+	flacPicture       = 6
+)
+
+// readFLAC walks the metadata blocks following the "fLaC" marker at off
+func readFLAC(r io.ReaderAt, size, off int64, opts Options) (*Metadata, error) {
+	md := &Metadata{Format: "flac"}
+	pos := off + 4
+	bestPicType := -1
+	for {
+		hdr, err := readSection(r, size, pos, 4)
+		if err != nil {
+			return nil, err
+		}
+		last := hdr[0]&0x80 != 0
+		typ := hdr[0] & 0x7f
+		n := int64(hdr[1])<<16 | int64(hdr[2])<<8 | int64(hdr[3])
+		pos += 4
+
+		switch typ {
+		case flacVorbisComment:
+			b, err := readSection(r, size, pos, n)
+			if err != nil {
+				return nil, err
+			}
+			applyVorbisComments(md, b)
+		case flacPicture:
+			// Only the header is needed to know there is art
+			md.HasPicture = true
+			if opts.Pictures {
+				b, err := readSection(r, size, pos, n)
+				if err != nil {
+					return nil, err
+				}
+				if pic, picType, ok := parseFLACPicture(b); ok && bestPicType != 3 && (picType == 3 || bestPicType < 0) {
+					md.Picture = pic
+					bestPicType = picType
+				}
+			}
+		}
+		pos += n
+		if last {
+			return md, nil
+		}
+	}
+}
+
+func applyVorbisComments(md *Metadata, b []byte) {
+	if len(b) < 4 {
+		return
+	}
+	vendorLen := int(binary.LittleEndian.Uint32(b))
+	if 4+vendorLen+4 > len(b) || vendorLen < 0 {
+		return
+	}
+	b = b[4+vendorLen:]
+	count := int(binary.LittleEndian.Uint32(b))
+	b = b[4:]
+	for i := 0; i < count && len(b) >= 4; i++ {
+		n := int(binary.LittleEndian.Uint32(b))
+		if n < 0 || 4+n > len(b) {
+			return
+		}
+		kv := string(b[4 : 4+n])
+		b = b[4+n:]
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		v = cleanValue(v)
+		// First value wins for multi-valued fields
+		switch strings.ToUpper(k) {
+		case "TITLE":
+			setOnce(&md.Title, v)
+		case "ARTIST":
+			setOnce(&md.Artist, v)
+		case "ALBUM":
+			setOnce(&md.Album, v)
+		case "DATE", "YEAR":
+			setOnce(&md.Year, yearOf(v))
+		case "GENRE":
+			setOnce(&md.Genre, v)
+		}
+	}
+}
+
+func setOnce(dst *string, v string) {
+	if *dst == "" {
+		*dst = v
+	}
+}
+
+func parseFLACPicture(b []byte) (*Picture, int, bool) {
+	u32 := func() (int, bool) {
+		if len(b) < 4 {
+			return 0, false
+		}
+		v := int(binary.BigEndian.Uint32(b))
+		b = b[4:]
+		return v, v >= 0
+	}
+	picType, ok := u32()
+	if !ok {
+		return nil, 0, false
+	}
+	n, ok := u32()
+	if !ok || n > len(b) {
+		return nil, 0, false
+	}
+	mime := string(b[:n])
+	b = b[n:]
+	if n, ok = u32(); !ok || n > len(b) {
+		return nil, 0, false
+	}
+	b = b[n:]
+	// width, height, depth, colors
+	if len(b) < 16 {
+		return nil, 0, false
+	}
+	b = b[16:]
+	if n, ok = u32(); !ok || n > len(b) || n == 0 {
+		return nil, 0, false
+	}
+	data := b[:n]
+	return &Picture{MIMEType: normalizeMIME(mime, data), Data: data}, picType, true
+}
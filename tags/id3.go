@@ -0,0 +1,348 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+var errBadID3 = errors.New("tags: malformed ID3v2 tag")
+
+// ID3v2 frame ids per major version for the fields we read
+var id3Frames = map[byte]map[string]string{
+	2: {"TT2": "title", "TP1": "artist", "TAL": "album", "TYE": "year", "TCO": "genre", "PIC": "picture"},
+	3: {"TIT2": "title", "TPE1": "artist", "TALB": "album", "TYER": "year", "TCON": "genre", "APIC": "picture"},
+	4: {"TIT2": "title", "TPE1": "artist", "TALB": "album", "TDRC": "year", "TYER": "year", "TCON": "genre", "APIC": "picture"},
+}
+
+// readID3v2 parses the tag at the start of the file and also returns the
+// offset right after it
+func readID3v2(r io.ReaderAt, size int64, opts Options) (*Metadata, int64, error) {
+	hdr, err := readSection(r, size, 0, 10)
+	if err != nil {
+		return nil, 0, err
+	}
+	major, flags := hdr[3], hdr[5]
+	frames, ok := id3Frames[major]
+	if !ok {
+		return nil, 0, ErrUnsupported
+	}
+	tagSize := int64(syncsafe(hdr[6:10]))
+	end := 10 + tagSize
+	if flags&0x10 != 0 {
+		// footer
+		end += 10
+	}
+	body, err := readSection(r, size, 10, tagSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	if flags&0x80 != 0 && major < 4 {
+		// v2.4 unsynchronises per frame instead
+		body = unsync(body)
+	}
+	if flags&0x40 != 0 && major >= 3 {
+		body, err = skipExtendedHeader(body, major)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	md := &Metadata{Format: "id3v2." + strconv.Itoa(int(major))}
+	var bestPicType = -1
+	for len(body) > 0 {
+		id, data, rest, ok := nextID3Frame(body, major)
+		if !ok {
+			break
+		}
+		body = rest
+		field, wanted := frames[id]
+		if !wanted {
+			continue
+		}
+		if field == "picture" {
+			mime, picType, img, ok := parseID3Picture(data, major)
+			if !ok {
+				continue
+			}
+			md.HasPicture = true
+			// Front cover (type 3) wins over whatever came first
+			if opts.Pictures && (bestPicType != 3 && (picType == 3 || bestPicType < 0)) {
+				md.Picture = &Picture{MIMEType: mime, Data: img}
+				bestPicType = picType
+			}
+			continue
+		}
+		val := decodeID3Text(data)
+		switch field {
+		case "title":
+			md.Title = val
+		case "artist":
+			md.Artist = val
+		case "album":
+			md.Album = val
+		case "year":
+			if md.Year == "" || id == "TDRC" {
+				md.Year = yearOf(val)
+			}
+		case "genre":
+			md.Genre = id3Genre(val)
+		}
+	}
+	return md, end, nil
+}
+
+func nextID3Frame(b []byte, major byte) (id string, data, rest []byte, ok bool) {
+	if major == 2 {
+		if len(b) < 6 || b[0] == 0 {
+			return "", nil, nil, false
+		}
+		n := int(b[3])<<16 | int(b[4])<<8 | int(b[5])
+		if 6+n > len(b) {
+			return "", nil, nil, false
+		}
+		return string(b[:3]), b[6 : 6+n], b[6+n:], true
+	}
+	if len(b) < 10 || b[0] == 0 {
+		return "", nil, nil, false
+	}
+	var n int
+	if major == 4 {
+		n = int(syncsafe(b[4:8]))
+	} else {
+		n = int(binary.BigEndian.Uint32(b[4:8]))
+	}
+	if n < 0 || 10+n > len(b) {
+		return "", nil, nil, false
+	}
+	data = b[10 : 10+n]
+	if major == 4 {
+		fl := b[9]
+		if fl&0x02 != 0 {
+			data = unsync(data)
+		}
+		if fl&0x01 != 0 && len(data) >= 4 {
+			// data length indicator
+			data = data[4:]
+		}
+	}
+	return string(b[:4]), data, b[10+n:], true
+}
+
+func skipExtendedHeader(b []byte, major byte) ([]byte, error) {
+	if len(b) < 4 {
+		return nil, errBadID3
+	}
+	var n int
+	if major == 4 {
+		// v2.4 counts the size field itself
+		n = int(syncsafe(b[:4]))
+	} else {
+		n = int(binary.BigEndian.Uint32(b[:4])) + 4
+	}
+	if n > len(b) {
+		return nil, errBadID3
+	}
+	return b[n:], nil
+}
+
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7f)<<21 | uint32(b[1]&0x7f)<<14 | uint32(b[2]&0x7f)<<7 | uint32(b[3]&0x7f)
+}
+
+// unsync undoes ID3 unsynchronisation (0xFF 0x00 -> 0xFF)
+func unsync(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte{0xff, 0x00}, []byte{0xff})
+}
+
+func decodeID3Text(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	// Multiple values are NUL separated; keep them readable
+	var values []string
+	for rest := b[1:]; len(rest) > 0; {
+		var s string
+		s, rest = decodeID3String(b[0], rest)
+		if s = cleanValue(s); s != "" {
+			values = append(values, s)
+		}
+	}
+	return strings.Join(values, "; ")
+}
+
+// decodeID3String decodes b with the given ID3 text encoding up to the
+// first terminator and returns the remaining bytes after it
+func decodeID3String(enc byte, b []byte) (string, []byte) {
+	switch enc {
+	case 1, 2:
+		end := len(b) &^ 1
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				end = i
+				break
+			}
+		}
+		rest := b[min(end+2, len(b)):]
+		return decodeUTF16(b[:end], enc == 2), rest
+	default:
+		end := bytes.IndexByte(b, 0)
+		rest := []byte(nil)
+		if end < 0 {
+			end = len(b)
+		} else {
+			rest = b[end+1:]
+		}
+		if enc == 3 {
+			return string(b[:end]), rest
+		}
+		return latin1(b[:end]), rest
+	}
+}
+
+func decodeUTF16(b []byte, bigEndian bool) string {
+	if len(b) >= 2 {
+		switch {
+		case b[0] == 0xff && b[1] == 0xfe:
+			bigEndian, b = false, b[2:]
+		case b[0] == 0xfe && b[1] == 0xff:
+			bigEndian, b = true, b[2:]
+		}
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		if bigEndian {
+			u[i] = binary.BigEndian.Uint16(b[2*i:])
+		} else {
+			u[i] = binary.LittleEndian.Uint16(b[2*i:])
+		}
+	}
+	return string(utf16.Decode(u))
+}
+
+func latin1(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+func parseID3Picture(b []byte, major byte) (mime string, picType int, data []byte, ok bool) {
+	if len(b) < 2 {
+		return "", 0, nil, false
+	}
+	enc := b[0]
+	b = b[1:]
+	if major == 2 {
+		if len(b) < 4 {
+			return "", 0, nil, false
+		}
+		switch strings.ToUpper(string(b[:3])) {
+		case "PNG":
+			mime = "image/png"
+		default:
+			mime = "image/jpeg"
+		}
+		b = b[3:]
+	} else {
+		i := bytes.IndexByte(b, 0)
+		if i < 0 {
+			return "", 0, nil, false
+		}
+		mime = string(b[:i])
+		b = b[i+1:]
+	}
+	if len(b) < 1 {
+		return "", 0, nil, false
+	}
+	picType = int(b[0])
+	_, b = decodeID3String(enc, b[1:])
+	if len(b) == 0 {
+		return "", 0, nil, false
+	}
+	return normalizeMIME(mime, b), picType, b, true
+}
+
+func normalizeMIME(mime string, data []byte) string {
+	mime = strings.ToLower(strings.TrimSpace(mime))
+	switch mime {
+	case "image/jpeg", "image/png":
+		return mime
+	case "image/jpg", "jpg", "jpeg":
+		return "image/jpeg"
+	case "png":
+		return "image/png"
+	}
+	if bytes.HasPrefix(data, []byte("\x89PNG")) {
+		return "image/png"
+	}
+	return "image/jpeg"
+}
+
+// id3Genre turns "(17)", "17" or "(17)Rock" style references to ID3v1
+// genre numbers into names
+func id3Genre(s string) string {
+	if strings.HasPrefix(s, "(") {
+		if i := strings.IndexByte(s, ')'); i > 0 {
+			if rest := strings.TrimSpace(s[i+1:]); rest != "" {
+				return rest
+			}
+			s = s[1:i]
+		}
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		if n >= 0 && n < len(id3v1Genres) {
+			return id3v1Genres[n]
+		}
+		return ""
+	}
+	return s
+}
+
+// readID3v1 reads the 128 byte tag at the end of the file
+func readID3v1(r io.ReaderAt, size int64) (*Metadata, error) {
+	if size < 128 {
+		return nil, ErrUnsupported
+	}
+	b, err := readSection(r, size, size-128, 128)
+	if err != nil {
+		return nil, err
+	}
+	if string(b[:3]) != "TAG" {
+		return nil, ErrUnsupported
+	}
+	field := func(b []byte) string { return cleanValue(latin1(bytes.TrimRight(b, "\x00 "))) }
+	md := &Metadata{
+		Format: "id3v1",
+		Title:  field(b[3:33]),
+		Artist: field(b[33:63]),
+		Album:  field(b[63:93]),
+		Year:   field(b[93:97]),
+	}
+	if g := int(b[127]); g < len(id3v1Genres) {
+		md.Genre = id3v1Genres[g]
+	}
+	return md, nil
+}
+
+var id3v1Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge",
+	"Hip-Hop", "Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B",
+	"Rap", "Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska",
+	"Death Metal", "Pranks", "Soundtrack", "Euro-Techno", "Ambient",
+	"Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance", "Classical",
+	"Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"AlternRock", "Bass", "Soul", "Punk", "Space", "Meditative",
+	"Instrumental Pop", "Instrumental Rock", "Ethnic", "Gothic", "Darkwave",
+	"Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap",
+	"Pop/Funk", "Jungle", "Native American", "Cabaret", "New Wave",
+	"Psychadelic", "Rave", "Showtunes", "Trailer", "Lo-Fi", "Tribal",
+	"Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll",
+	"Hard Rock",
+}
@@ -0,0 +1,157 @@
+package tags
+
+import (
+	"encoding/binary"
+	"io"
+	"strconv"
+)
+
+// iTunes metadata item atoms; \xa9 is the copyright sign they start with
+var mp4Items = map[string]string{
+	"\xa9nam": "title",
+	"\xa9ART": "artist",
+	"aART":    "albumartist",
+	"\xa9alb": "album",
+	"\xa9day": "year",
+	"\xa9gen": "genre",
+	"gnre":    "genre",
+	"covr":    "picture",
+}
+
+const (
+	mp4DataJPEG = 13
+	mp4DataPNG  = 14
+)
+
+type mp4Atom struct {
+	typ       string
+	off, size int64 // payload offset and size
+}
+
+func readMP4(r io.ReaderAt, size int64, opts Options) (*Metadata, error) {
+	moov, ok, err := findAtom(r, size, 0, size, "moov")
+	if err != nil || !ok {
+		return nil, orUnsupported(err)
+	}
+	udta, ok, err := findAtom(r, size, moov.off, moov.size, "udta")
+	if err != nil || !ok {
+		return &Metadata{Format: "mp4"}, err
+	}
+	meta, ok, err := findAtom(r, size, udta.off, udta.size, "meta")
+	if err != nil || !ok {
+		return &Metadata{Format: "mp4"}, err
+	}
+	// meta is a full box: 4 bytes of version and flags before its children
+	ilst, ok, err := findAtom(r, size, meta.off+4, meta.size-4, "ilst")
+	if err != nil || !ok {
+		return &Metadata{Format: "mp4"}, err
+	}
+
+	md := &Metadata{Format: "mp4"}
+	var albumArtist string
+	err = eachAtom(r, size, ilst.off, ilst.size, func(item mp4Atom) (bool, error) {
+		field, wanted := mp4Items[item.typ]
+		if !wanted {
+			return true, nil
+		}
+		data, ok, err := findAtom(r, size, item.off, item.size, "data")
+		if err != nil || !ok || data.size < 8 {
+			return err == nil, err
+		}
+		if field == "picture" {
+			md.HasPicture = true
+			if !opts.Pictures || md.Picture != nil {
+				return true, nil
+			}
+		}
+		b, err := readSection(r, size, data.off, data.size)
+		if err != nil {
+			return false, err
+		}
+		kind := binary.BigEndian.Uint32(b) & 0xffffff
+		val := b[8:]
+		switch field {
+		case "picture":
+			mime := "image/jpeg"
+			if kind == mp4DataPNG {
+				mime = "image/png"
+			}
+			md.Picture = &Picture{MIMEType: normalizeMIME(mime, val), Data: val}
+		case "genre":
+			if item.typ == "gnre" && len(val) >= 2 {
+				// ID3v1 genre number plus one
+				setOnce(&md.Genre, id3Genre(strconv.Itoa(int(binary.BigEndian.Uint16(val))-1)))
+			} else {
+				md.Genre = cleanValue(string(val))
+			}
+		case "title":
+			md.Title = cleanValue(string(val))
+		case "artist":
+			md.Artist = cleanValue(string(val))
+		case "albumartist":
+			albumArtist = cleanValue(string(val))
+		case "album":
+			md.Album = cleanValue(string(val))
+		case "year":
+			md.Year = yearOf(string(val))
+		}
+		return true, nil
+	})
+	if md.Artist == "" {
+		md.Artist = albumArtist
+	}
+	return md, err
+}
+
+func orUnsupported(err error) error {
+	if err != nil {
+		return err
+	}
+	return ErrUnsupported
+}
+
+func findAtom(r io.ReaderAt, size, off, n int64, typ string) (mp4Atom, bool, error) {
+	var found mp4Atom
+	err := eachAtom(r, size, off, n, func(a mp4Atom) (bool, error) {
+		if a.typ == typ {
+			found = a
+			return false, nil
+		}
+		return true, nil
+	})
+	return found, found.typ != "", err
+}
+
+// eachAtom calls fn for every atom in [off, off+n) until it returns false
+func eachAtom(r io.ReaderAt, size, off, n int64, fn func(mp4Atom) (bool, error)) error {
+	end := off + n
+	for off+8 <= end {
+		hdr, err := readSection(r, size, off, 8)
+		if err != nil {
+			return err
+		}
+		atomSize := int64(binary.BigEndian.Uint32(hdr))
+		typ := string(hdr[4:8])
+		hdrLen := int64(8)
+		switch atomSize {
+		case 0:
+			atomSize = end - off
+		case 1:
+			ext, err := readSection(r, size, off+8, 8)
+			if err != nil {
+				return err
+			}
+			atomSize = int64(binary.BigEndian.Uint64(ext))
+			hdrLen = 16
+		}
+		if atomSize < hdrLen || off+atomSize > end {
+			return nil
+		}
+		more, err := fn(mp4Atom{typ: typ, off: off + hdrLen, size: atomSize - hdrLen})
+		if err != nil || !more {
+			return err
+		}
+		off += atomSize
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+// Package tags reads track metadata and embedded cover art from MP3
+// (ID3v2 and ID3v1), FLAC (Vorbis comments) and MP4/M4A (iTunes atoms)
+// files without any external tools.
+package tags
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+var ErrUnsupported = errors.New("tags: unsupported file format")
+
+type Metadata struct {
+	Format string
+	Title  string
+	Artist string
+	Album  string
+	Year   string
+	Genre  string
+	// HasPicture is set even when the picture itself wasn't read
+	HasPicture bool
+	Picture    *Picture
+}
+
+type Picture struct {
+	MIMEType string
+	Data     []byte
+}
+
+type Options struct {
+	// Pictures reads embedded art into Metadata.Picture. Scanning a large
+	// library is much faster without it.
+	Pictures bool
+}
+
+func ReadFile(path string, opts Options) (*Metadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return Read(f, fi.Size(), opts)
+}
+
+// Read detects the format from the file's magic bytes
+func Read(r io.ReaderAt, size int64, opts Options) (*Metadata, error) {
+	var head [12]byte
+	n, err := r.ReadAt(head[:], 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	h := head[:n]
+	switch {
+	case bytes.HasPrefix(h, []byte("fLaC")):
+		return readFLAC(r, size, 0, opts)
+	case len(h) >= 8 && string(h[4:8]) == "ftyp":
+		return readMP4(r, size, opts)
+	case bytes.HasPrefix(h, []byte("ID3")):
+		// FLAC files sometimes carry an ID3v2 tag in front
+		md, end, err := readID3v2(r, size, opts)
+		if err != nil {
+			return nil, err
+		}
+		var magic [4]byte
+		if _, err := r.ReadAt(magic[:], end); err == nil && string(magic[:]) == "fLaC" {
+			if fl, err := readFLAC(r, size, end, opts); err == nil {
+				return merge(fl, md), nil
+			}
+		}
+		if v1, err := readID3v1(r, size); err == nil {
+			md = merge(md, v1)
+		}
+		return md, nil
+	}
+	// MP3 without ID3v2 but maybe with a trailing ID3v1 tag
+	return readID3v1(r, size)
+}
+
+// merge fills empty fields of a from b
+func merge(a, b *Metadata) *Metadata {
+	fill := func(dst *string, src string) {
+		if *dst == "" {
+			*dst = src
+		}
+	}
+	fill(&a.Title, b.Title)
+	fill(&a.Artist, b.Artist)
+	fill(&a.Album, b.Album)
+	fill(&a.Year, b.Year)
+	fill(&a.Genre, b.Genre)
+	if !a.HasPicture && b.HasPicture {
+		a.HasPicture = true
+		a.Picture = b.Picture
+	}
+	return a
+}
+
+// readSection reads n bytes at off, refusing sizes beyond the file so a
+// corrupt length can't allocate gigabytes
+func readSection(r io.ReaderAt, size, off, n int64) ([]byte, error) {
+	if off < 0 || n < 0 || off+n > size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, off); err != nil && !(err == io.EOF && off+n == size) {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func cleanValue(s string) string {
+	return strings.TrimSpace(strings.TrimRight(s, "\x00"))
+}
+
+// yearOf keeps the year from dates like 2019-05-03 or 2019-05-03T00:00:00Z
+func yearOf(s string) string {
+	s = cleanValue(s)
+	if len(s) >= 4 && isDigits(s[:4]) {
+		return s[:4]
+	}
+	return s
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
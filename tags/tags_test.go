@@ -0,0 +1,258 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var (
+	jpegData = []byte("\xff\xd8\xff\xe0fake jpeg")
+	pngData  = []byte("\x89PNG\r\n\x1a\nfake png")
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+		opts Options
+		want *Metadata
+		err  error
+	}{
+		{
+			name: "id3v2.3",
+			file: id3v2(3, 0,
+				id3Frame(3, "TIT2", latin1Text("Song")),
+				id3Frame(3, "TPE1", utf16Text("Artíst")),
+				id3Frame(3, "TALB", latin1Text("Album")),
+				id3Frame(3, "TYER", latin1Text("1999")),
+				id3Frame(3, "TCON", latin1Text("(17)")),
+				id3Frame(3, "APIC", apic("image/png", 0, pngData)),
+				id3Frame(3, "APIC", apic("image/jpg", 3, jpegData)),
+			),
+			opts: Options{Pictures: true},
+			want: &Metadata{Format: "id3v2.3", Title: "Song", Artist: "Artíst", Album: "Album", Year: "1999", Genre: "Rock",
+				HasPicture: true, Picture: &Picture{MIMEType: "image/jpeg", Data: jpegData}},
+		},
+		{
+			name: "id3v2.4 with several values",
+			file: id3v2(4, 0,
+				id3Frame(4, "TIT2", utf8Text("Sóng")),
+				id3Frame(4, "TPE1", utf8Text("A\x00B")),
+				id3Frame(4, "TYER", latin1Text("1990")),
+				id3Frame(4, "TDRC", utf8Text("2019-05-03")),
+				id3Frame(4, "TCON", utf8Text("Synthwave")),
+				id3Frame(4, "APIC", apic("image/png", 3, pngData)),
+			),
+			want: &Metadata{Format: "id3v2.4", Title: "Sóng", Artist: "A; B", Year: "2019", Genre: "Synthwave", HasPicture: true},
+		},
+		{
+			name: "id3v2.2",
+			file: id3v2(2, 0,
+				id3Frame(2, "TT2", latin1Text("Old")),
+				id3Frame(2, "TP1", latin1Text("Tag")),
+				id3Frame(2, "PIC", append([]byte("\x00PNG\x03\x00"), pngData...)),
+			),
+			opts: Options{Pictures: true},
+			want: &Metadata{Format: "id3v2.2", Title: "Old", Artist: "Tag", HasPicture: true, Picture: &Picture{MIMEType: "image/png", Data: pngData}},
+		},
+		{
+			name: "id3v2 with extended header",
+			file: id3v2(3, 0x40, append([]byte{0, 0, 0, 6, 0, 0, 0, 0, 0, 0}, id3Frame(3, "TIT2", latin1Text("Ext"))...)),
+			want: &Metadata{Format: "id3v2.3", Title: "Ext"},
+		},
+		{
+			name: "id3v2 filled in from id3v1",
+			file: append(id3v2(3, 0, id3Frame(3, "TIT2", latin1Text("Two"))), id3v1("One", "Artist", "Album", "2001", 13)...),
+			want: &Metadata{Format: "id3v2.3", Title: "Two", Artist: "Artist", Album: "Album", Year: "2001", Genre: "Pop"},
+		},
+		{
+			name: "id3v1 only",
+			file: append(bytes.Repeat([]byte{0xff}, 64), id3v1("Title", "Artist", "Album", "1985", 200)...),
+			want: &Metadata{Format: "id3v1", Title: "Title", Artist: "Artist", Album: "Album", Year: "1985"},
+		},
+		{
+			name: "flac",
+			file: flac(
+				flacBlock(flacVorbisComment, vorbis("TITLE=Song", "artist=First", "ARTIST=Second", "DATE=2020-01-01", "noequals")),
+				flacBlock(flacPicture, flacPic(0, "image/png", pngData)),
+				flacBlock(flacPicture, flacPic(3, "image/jpeg", jpegData)),
+			),
+			opts: Options{Pictures: true},
+			want: &Metadata{Format: "flac", Title: "Song", Artist: "First", Year: "2020", HasPicture: true, Picture: &Picture{MIMEType: "image/jpeg", Data: jpegData}},
+		},
+		{
+			name: "flac after an id3v2 tag",
+			file: append(id3v2(3, 0, id3Frame(3, "TALB", latin1Text("From ID3"))), flac(flacBlock(flacVorbisComment, vorbis("TITLE=Song")))...),
+			want: &Metadata{Format: "flac", Title: "Song", Album: "From ID3"},
+		},
+		{
+			name: "mp4",
+			file: mp4(
+				mp4Item("\xa9nam", 1, "Song"),
+				mp4Item("aART", 1, "Album Artist"),
+				mp4Item("\xa9alb", 1, "Album"),
+				mp4Item("\xa9day", 1, "2018-04-01T00:00:00Z"),
+				mp4Item("gnre", 0, "\x00\x12"),
+				mp4Item("covr", mp4DataPNG, string(pngData)),
+			),
+			opts: Options{Pictures: true},
+			want: &Metadata{Format: "mp4", Title: "Song", Artist: "Album Artist", Album: "Album", Year: "2018", Genre: "Rock",
+				HasPicture: true, Picture: &Picture{MIMEType: "image/png", Data: pngData}},
+		},
+		{
+			name: "mp4 without metadata",
+			file: atom("ftyp", []byte("M4A \x00\x00\x00\x00")),
+			err:  ErrUnsupported,
+		},
+		{
+			name: "unknown format",
+			file: []byte("RIFF....WAVEfmt "),
+			err:  ErrUnsupported,
+		},
+		{
+			name: "truncated id3v2",
+			file: id3v2(3, 0, id3Frame(3, "TIT2", latin1Text("Song")))[:20],
+			err:  errAny,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Read(bytes.NewReader(tt.file), int64(len(tt.file)), tt.opts)
+			if tt.err != nil {
+				if err == nil || (tt.err != errAny && !errors.Is(err, tt.err)) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %+v %+v\nwant %+v %+v", got, got.Picture, tt.want, tt.want.Picture)
+			}
+		})
+	}
+}
+
+var errAny = errors.New("any error")
+
+func TestID3Genre(t *testing.T) {
+	tests := map[string]string{
+		"17":        "Rock",
+		"(17)":      "Rock",
+		"(17)Metal": "Metal",
+		"(999)":     "",
+		"Vaporwave": "Vaporwave",
+		"":          "",
+	}
+	for in, want := range tests {
+		if got := id3Genre(in); got != want {
+			t.Errorf("id3Genre(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestUnsync(t *testing.T) {
+	got := unsync([]byte{0xff, 0x00, 0xe0, 0x01, 0xff, 0x00})
+	if want := []byte{0xff, 0xe0, 0x01, 0xff}; !bytes.Equal(got, want) {
+		t.Errorf("unsync = % x, want % x", got, want)
+	}
+}
+
+func id3v2(major, flags byte, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	n := len(body)
+	hdr := []byte{'I', 'D', '3', major, 0, flags, byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+	return append(hdr, body...)
+}
+
+func id3Frame(major byte, id string, data []byte) []byte {
+	n := len(data)
+	switch major {
+	case 2:
+		return append(append([]byte(id), byte(n>>16), byte(n>>8), byte(n)), data...)
+	case 4:
+		return append(append([]byte(id), byte(n>>21&0x7f), byte(n>>14&0x7f), byte(n>>7&0x7f), byte(n&0x7f), 0, 0), data...)
+	}
+	return append(binary.BigEndian.AppendUint32([]byte(id), uint32(n)), append([]byte{0, 0}, data...)...)
+}
+
+func latin1Text(s string) []byte { return append([]byte{0}, s...) }
+func utf8Text(s string) []byte   { return append([]byte{3}, s...) }
+
+func utf16Text(s string) []byte {
+	b := []byte{1, 0xff, 0xfe}
+	for _, r := range s {
+		b = binary.LittleEndian.AppendUint16(b, uint16(r))
+	}
+	return b
+}
+
+func apic(mime string, picType byte, data []byte) []byte {
+	b := append([]byte{0}, mime...)
+	b = append(b, 0, picType)
+	b = append(b, "cover\x00"...)
+	return append(b, data...)
+}
+
+func id3v1(title, artist, album, year string, genre byte) []byte {
+	b := make([]byte, 128)
+	copy(b, "TAG")
+	copy(b[3:33], title)
+	copy(b[33:63], artist)
+	copy(b[63:93], album)
+	copy(b[93:97], year)
+	b[127] = genre
+	return b
+}
+
+func flac(blocks ...[]byte) []byte {
+	blocks[len(blocks)-1][0] |= 0x80
+	return append([]byte("fLaC"), bytes.Join(blocks, nil)...)
+}
+
+func flacBlock(typ byte, data []byte) []byte {
+	n := len(data)
+	return append([]byte{typ, byte(n >> 16), byte(n >> 8), byte(n)}, data...)
+}
+
+func vorbis(comments ...string) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, 6)
+	b = append(b, "vendor"...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(comments)))
+	for _, c := range comments {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(c)))
+		b = append(b, c...)
+	}
+	return b
+}
+
+func flacPic(picType uint32, mime string, data []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, picType)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(mime)))
+	b = append(b, mime...)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = append(b, make([]byte, 16)...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	return append(b, data...)
+}
+
+func atom(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	return append(binary.BigEndian.AppendUint32(nil, uint32(8+len(body))), append([]byte(typ), body...)...)
+}
+
+func mp4Item(typ string, kind uint32, value string) []byte {
+	data := binary.BigEndian.AppendUint32(nil, kind)
+	data = append(data, 0, 0, 0, 0)
+	return atom(typ, atom("data", data, []byte(value)))
+}
+
+func mp4(items ...[]byte) []byte {
+	ilst := atom("ilst", items...)
+	meta := atom("meta", []byte{0, 0, 0, 0}, atom("hdlr", make([]byte, 25)), ilst)
+	return append(atom("ftyp", []byte("M4A \x00\x00\x00\x00")), atom("moov", atom("udta", meta))...)
+}
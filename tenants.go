@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tenantConfig is one entry of the -tenants file. Overrides and the art
+// folder they may use are per tenant since they're edited from the
+// tenant's admin page; a tenant without a blocklist uses -blocklist.
+type tenantConfig struct {
+	Name      string `json:"name"`
+	Token     string `json:"token"`
+	Overrides string `json:"overrides,omitempty"`
+	ArtDir    string `json:"art_dir,omitempty"`
+	Blocklist string `json:"blocklist,omitempty"`
+	StateFile string `json:"state_file,omitempty"`
+}
+
+func loadTenants(path string) ([]tenantConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tenants []tenantConfig
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(tenants) == 0 {
+		return nil, fmt.Errorf("%s: no tenants", path)
+	}
+	for i, t := range tenants {
+		if t.Token == "" {
+			return nil, fmt.Errorf("%s: tenant %q has no token", path, t.Name)
+		}
+		if t.StateFile == "" {
+			tenants[i].StateFile = tenantStateFile(cfg.stateFile, t.Name)
+		}
+	}
+	return tenants, nil
+}
+
+// tenantStateFile puts the tenant name into the -state-file name, so
+// piff-music-state.json becomes piff-music-state-alice.json
+func tenantStateFile(stateFile, name string) string {
+	if stateFile == "" {
+		return ""
+	}
+	ext := filepath.Ext(stateFile)
+	return strings.TrimSuffix(stateFile, ext) + "-" + name + ext
+}